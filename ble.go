@@ -0,0 +1,957 @@
+//go:build winc_ble
+
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+// The WINC3400 exposes its BLE controller through the BLE API. Its messages are tunneled through the Wi-Fi group and
+// addressed to the GAP and GATT tasks of the BLE stack.
+//
+// The BLE API is only built with the winc_ble tag. Its message IDs, operations and parameter layouts follow the
+// RW-BLE stack the BLE API is built on and have not been checked against the BLE API headers of the WINC3400 firmware
+// yet, so the API may change.
+
+var (
+	ErrBLEUnsupported        = errors.New("chip has no BLE controller")
+	ErrBLEAdvertisingStopped = errors.New("BLE advertising was stopped")
+)
+
+// bleState is the BLE state of the driver.
+type bleState struct {
+	bleServices    []*BLEService
+	bleValues      map[uint16][]byte // Values of the characteristics by handle
+	bleScanning    bool
+	bleScanResults []BLEAdvertisement
+	bleConnection  uint16
+	bleCentral     net.HardwareAddr // Address of the connected central
+	bleMutex       sync.Mutex
+
+	// bleCommandMutex serializes the BLE calls that wait for replies. It also guards bleAdvertising.
+	bleCommandMutex sync.Mutex
+	bleAdvertising  bool
+
+	// bleAirMutex serializes advertising and scanning, since the GAP manager runs one air operation at a time
+	bleAirMutex sync.Mutex
+}
+
+const (
+	bleMessageType      = 0x05
+	bleHeaderLength     = 9
+	bleMaxMessageLength = 512
+	bleMaxDataLength    = 31
+
+	bleTaskGattm uint16 = 11
+	bleTaskGattc uint16 = 12
+	bleTaskGapm  uint16 = 13
+	bleTaskGapc  uint16 = 14
+	bleTaskApp   uint16 = 63
+)
+
+// BLE API messages. The ID of a message starts at the task it belongs to shifted by 10 bits.
+const (
+	bleGattmAddSvcReq        uint16 = 0x2C00
+	bleGattmAddSvcRsp        uint16 = 0x2C01
+	bleGattmAttSetValueReq   uint16 = 0x2C0A
+	bleGattmAttSetValueRsp   uint16 = 0x2C0B
+	bleGattcSendEvtCmd       uint16 = 0x3010
+	bleGattcWriteReqInd      uint16 = 0x3015
+	bleGattcWriteCfm         uint16 = 0x3016
+	bleGapmCmpEvt            uint16 = 0x3400
+	bleGapmCancelCmd         uint16 = 0x3403
+	bleGapmStartAdvertiseCmd uint16 = 0x340D
+	bleGapmStartScanCmd      uint16 = 0x340E
+	bleGapmAdvReportInd      uint16 = 0x3410
+	bleGapcConnectionReqInd  uint16 = 0x3801
+	bleGapcConnectionCfm     uint16 = 0x3802
+	bleGapcDisconnectInd     uint16 = 0x3803
+)
+
+// GAP operations completed by bleGapmCmpEvt
+const (
+	bleOpCancel                byte = 0x03
+	bleOpAdvertiseNonConnected byte = 0x0E
+	bleOpAdvertiseUndirected   byte = 0x0F
+	bleOpScanActive            byte = 0x10
+	bleOpScanPassive           byte = 0x11
+	bleOpNotify                byte = 0x12
+)
+
+const (
+	bleStatusOK       byte = 0x00
+	bleStatusCanceled byte = 0x44
+
+	bleAttInvalidHandle byte = 0x01
+	bleAttInvalidLength byte = 0x0D
+
+	// Intervals are counted in units of 0.625 ms
+	bleIntervalUnit = 625 * time.Microsecond
+)
+
+// BLE properties of a GATT characteristic
+const (
+	BLEPropertyRead                 = 0x02
+	BLEPropertyWriteWithoutResponse = 0x04
+	BLEPropertyWrite                = 0x08
+	BLEPropertyNotify               = 0x10
+)
+
+// bleMessage is a message exchanged with a task of the BLE stack.
+type bleMessage struct {
+	id     uint16
+	dest   uint16
+	src    uint16
+	params []byte
+}
+
+// bytes returns the message prefixed with its length as expected by OpcodeWifiReqBleApiSend.
+func (m *bleMessage) bytes() []byte {
+	length := bleHeaderLength + len(m.params)
+	buf := make([]byte, 2+bleHeaderLength, 2+length)
+	binary.LittleEndian.PutUint16(buf[0:], uint16(length))
+	buf[2] = bleMessageType
+	binary.LittleEndian.PutUint16(buf[3:], m.id)
+	binary.LittleEndian.PutUint16(buf[5:], m.dest)
+	binary.LittleEndian.PutUint16(buf[7:], m.src)
+	binary.LittleEndian.PutUint16(buf[9:], uint16(len(m.params)))
+	return append(buf, m.params...)
+}
+
+// read decodes a message received with OpcodeWifiRespBleApiRecv.
+func (m *bleMessage) read(data []byte) bool {
+	if len(data) < 2+bleHeaderLength {
+		return false
+	}
+
+	length := int(binary.LittleEndian.Uint16(data[0:]))
+	data = data[2:]
+	if length < bleHeaderLength || length > len(data) || data[0] != bleMessageType {
+		return false
+	}
+
+	m.id = binary.LittleEndian.Uint16(data[1:])
+	m.dest = binary.LittleEndian.Uint16(data[3:])
+	m.src = binary.LittleEndian.Uint16(data[5:])
+
+	paramLength := int(binary.LittleEndian.Uint16(data[7:]))
+	if paramLength > length-bleHeaderLength {
+		return false
+	}
+
+	m.params = data[bleHeaderLength : bleHeaderLength+paramLength]
+	return true
+}
+
+// BLEUUID is a 128-bit UUID in the little-endian byte order used over the air.
+type BLEUUID [16]byte
+
+// bleBaseUUID is the Bluetooth base UUID 00000000-0000-1000-8000-00805F9B34FB
+var bleBaseUUID = BLEUUID{0xFB, 0x34, 0x9B, 0x5F, 0x80, 0x00, 0x00, 0x80, 0x00, 0x10, 0x00, 0x00}
+
+var (
+	bleUUIDCharacteristic = NewBLEUUID16(0x2803)
+	bleUUIDClientConfig   = NewBLEUUID16(0x2902)
+)
+
+// NewBLEUUID16 returns the 128-bit form of a 16-bit UUID assigned by the Bluetooth SIG.
+func NewBLEUUID16(uuid uint16) BLEUUID {
+	u := bleBaseUUID
+	binary.LittleEndian.PutUint16(u[12:], uuid)
+	return u
+}
+
+// ParseBLEUUID parses a UUID of the form 6e400001-b5a3-f393-e0a9-e50e24dcca9e.
+func ParseBLEUUID(s string) (u BLEUUID, err error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, ErrInvalidParameter
+	}
+
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil {
+		return u, ErrInvalidParameter
+	}
+
+	for i := range b {
+		u[len(u)-1-i] = b[i]
+	}
+
+	return u, nil
+}
+
+func (u BLEUUID) String() string {
+	var b [16]byte
+	for i := range u {
+		b[len(b)-1-i] = u[i]
+	}
+
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// bleAddress converts an address in over the air order to the order it is usually printed in.
+func bleAddress(data []byte) net.HardwareAddr {
+	addr := make(net.HardwareAddr, 6)
+	for i := range addr {
+		addr[i] = data[5-i]
+	}
+
+	return addr
+}
+
+// BLEError is the status of a failed BLE operation.
+type BLEError uint8
+
+func (b BLEError) Error() string {
+	return fmt.Sprintf("BLE operation failed with status %#x", uint8(b))
+}
+
+func bleStatusError(status byte) error {
+	if status == bleStatusOK {
+		return nil
+	}
+
+	return BLEError(status)
+}
+
+// bleRequestKey returns the key of a BLE reply. Completion events of the GAP manager are also matched by the
+// operation they complete. Other replies use operation 0.
+func bleRequestKey(id uint16, operation byte) requestKey {
+	return requestKey{
+		group:     GroupWIFI,
+		opcode:    OpcodeWifiRespBleApiRecv,
+		socket:    -1,
+		message:   id,
+		operation: operation,
+	}
+}
+
+// bleSupported returns ErrBLEUnsupported unless the chip has a BLE controller.
+func (w *WINC) bleSupported() error {
+	if w.hif.Family() != protocol.ChipFamilyWINC3400 {
+		return ErrBLEUnsupported
+	}
+
+	return nil
+}
+
+// bleSend sends a message without taking the mutex of the driver. The interrupt callbacks and calls already holding it
+// use this. Other calls use bleCommand.
+func (w *WINC) bleSend(msg *bleMessage) error {
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqBleApiSend, msg.bytes(), nil, 0)
+}
+
+// bleCommand sends a message that has no reply.
+func (w *WINC) bleCommand(msg *bleMessage) error {
+	return w.send(GroupWIFI, OpcodeWifiReqBleApiSend, msg.bytes(), nil, 0)
+}
+
+// bleRequest sends a message and waits for the reply with the key.
+func (w *WINC) bleRequest(ctx context.Context, key requestKey, msg *bleMessage) (any, error) {
+	return w.request(ctx, key, OpcodeWifiReqBleApiSend, msg.bytes(), nil, 0)
+}
+
+// BLEAdvertisingOptions configures the advertisements sent by StartBLEAdvertising.
+type BLEAdvertisingOptions struct {
+	// Interval between advertising events. Defaults to 100 ms when zero.
+	Interval time.Duration
+
+	// Data is the advertising payload of up to 31 bytes
+	Data []byte
+
+	// ScanResponse is sent to centrals scanning actively. Up to 31 bytes.
+	ScanResponse []byte
+
+	// Connectable lets centrals connect to the GATT server
+	Connectable bool
+}
+
+// StartBLEAdvertising advertises until a central connects, StopBLEAdvertising is called or the context is done. It
+// returns nil once a central connected and ErrBLEAdvertisingStopped once StopBLEAdvertising was called. Advertising is
+// stopped before returning if the context is done.
+func (w *WINC) StartBLEAdvertising(ctx context.Context, options BLEAdvertisingOptions) error {
+	w.bleAirMutex.Lock()
+	defer w.bleAirMutex.Unlock()
+
+	if err := w.bleSupported(); err != nil {
+		return err
+	}
+
+	interval := options.Interval
+	if interval == 0 {
+		interval = 100 * time.Millisecond
+	}
+
+	units := interval / bleIntervalUnit
+	if units < 0x20 || units > 0x4000 || len(options.Data) > bleMaxDataLength ||
+		len(options.ScanResponse) > bleMaxDataLength {
+
+		return ErrInvalidParameter
+	}
+
+	params := make([]byte, 72)
+	params[0] = bleOpAdvertiseNonConnected
+	if options.Connectable {
+		params[0] = bleOpAdvertiseUndirected
+	}
+
+	binary.LittleEndian.PutUint16(params[2:], uint16(units))
+	binary.LittleEndian.PutUint16(params[4:], uint16(units))
+	params[6] = 0x07 // All advertising channels
+	params[7] = 0x01 // General discoverable
+	params[8] = byte(len(options.Data))
+	copy(params[9:40], options.Data)
+	params[40] = byte(len(options.ScanResponse))
+	copy(params[41:72], options.ScanResponse)
+
+	// StopBLEAdvertising must not cancel before the advertising is started
+	w.bleCommandMutex.Lock()
+	r := w.pending.add(bleRequestKey(bleGapmCmpEvt, params[0]))
+	err := w.bleCommand(&bleMessage{
+		id:     bleGapmStartAdvertiseCmd,
+		dest:   bleTaskGapm,
+		src:    bleTaskApp,
+		params: params,
+	})
+
+	w.bleAdvertising = err == nil
+	w.bleCommandMutex.Unlock()
+
+	if err != nil {
+		w.pending.remove(r)
+		return err
+	}
+
+	defer func() {
+		w.bleCommandMutex.Lock()
+		w.bleAdvertising = false
+		w.bleCommandMutex.Unlock()
+	}()
+
+	select {
+	case reply := <-r.reply:
+		if err, ok := reply.(error); ok {
+			// The driver was reset
+			return err
+		}
+
+		switch status := reply.(byte); status {
+		case bleStatusOK:
+			// A central connected
+			return nil
+		case bleStatusCanceled:
+			return ErrBLEAdvertisingStopped
+		default:
+			return BLEError(status)
+		}
+	case <-ctx.Done():
+		// Stop advertising before giving up
+		cancelCtx, cancel := w.replyContext()
+		defer cancel()
+
+		w.bleCancel(cancelCtx)
+		w.pending.wait(cancelCtx, r)
+		return contextError(ctx)
+	}
+}
+
+// StopBLEAdvertising stops the advertising of a running StartBLEAdvertising call and waits for the controller to
+// confirm. It does nothing if the driver isn't advertising.
+func (w *WINC) StopBLEAdvertising(ctx context.Context) error {
+	w.bleCommandMutex.Lock()
+	defer w.bleCommandMutex.Unlock()
+
+	if err := w.bleSupported(); err != nil {
+		return err
+	}
+
+	if !w.bleAdvertising {
+		return nil
+	}
+
+	return w.bleCancel(ctx)
+}
+
+// bleCancel cancels the running air operation of the GAP manager.
+func (w *WINC) bleCancel(ctx context.Context) error {
+	reply, err := w.bleRequest(ctx, bleRequestKey(bleGapmCmpEvt, bleOpCancel), &bleMessage{
+		id:     bleGapmCancelCmd,
+		dest:   bleTaskGapm,
+		src:    bleTaskApp,
+		params: []byte{bleOpCancel, 0, 0, 0},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return bleStatusError(reply.(byte))
+}
+
+// BLEScanOptions configures ScanBLE.
+type BLEScanOptions struct {
+	// Duration of the scan. Defaults to 5 seconds when zero.
+	Duration time.Duration
+
+	// Active requests the scan response of the advertisers
+	Active bool
+}
+
+// BLEAdvertisement is an advertisement received by ScanBLE.
+type BLEAdvertisement struct {
+	Address     net.HardwareAddr
+	AddressType uint8
+	EventType   uint8
+	RSSI        int8
+	Data        []byte
+}
+
+func (b *BLEAdvertisement) read(data []byte) {
+	b.EventType = data[0]
+	b.AddressType = data[1]
+	b.Address = bleAddress(data[2:8])
+
+	length := int(data[8])
+	if length > bleMaxDataLength {
+		length = bleMaxDataLength
+	}
+
+	b.Data = append([]byte(nil), data[9:9+length]...)
+	b.RSSI = int8(data[40])
+}
+
+// ScanBLE returns the advertisements received during the scan. Duplicate advertisements are filtered by the
+// controller.
+func (w *WINC) ScanBLE(ctx context.Context, options BLEScanOptions) ([]BLEAdvertisement, error) {
+	w.bleAirMutex.Lock()
+	defer w.bleAirMutex.Unlock()
+
+	if err := w.bleSupported(); err != nil {
+		return nil, err
+	}
+
+	duration := options.Duration
+	if duration == 0 {
+		duration = 5 * time.Second
+	} else if duration < 0 {
+		return nil, ErrInvalidParameter
+	}
+
+	operation := bleOpScanPassive
+	if options.Active {
+		operation = bleOpScanActive
+	}
+
+	params := make([]byte, 8)
+	params[0] = operation
+	binary.LittleEndian.PutUint16(params[2:], 0x10) // 10 ms interval
+	binary.LittleEndian.PutUint16(params[4:], 0x10) // 10 ms window
+	params[6] = 0x00                                // General discovery
+	params[7] = 0x01                                // Filter duplicates
+
+	w.bleMutex.Lock()
+	w.bleScanning = true
+	w.bleScanResults = nil
+	w.bleMutex.Unlock()
+
+	defer func() {
+		w.bleMutex.Lock()
+		w.bleScanning = false
+		w.bleScanResults = nil
+		w.bleMutex.Unlock()
+	}()
+
+	// The scan completes once it is cancelled
+	r := w.pending.add(bleRequestKey(bleGapmCmpEvt, operation))
+	if err := w.bleCommand(&bleMessage{
+		id:     bleGapmStartScanCmd,
+		dest:   bleTaskGapm,
+		src:    bleTaskApp,
+		params: params,
+	}); err != nil {
+		w.pending.remove(r)
+		return nil, err
+	}
+
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case reply := <-r.reply:
+		if err, ok := reply.(error); ok {
+			// The driver was reset
+			return nil, err
+		}
+
+		// The controller ended the scan early
+		if err := bleStatusError(reply.(byte)); err != nil {
+			return nil, err
+		}
+	case <-timer.C:
+		if err := w.bleCancel(ctx); err != nil {
+			w.pending.remove(r)
+			return nil, err
+		}
+
+		reply, err := w.pending.wait(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+
+		if status := reply.(byte); status != bleStatusCanceled {
+			if err = bleStatusError(status); err != nil {
+				return nil, err
+			}
+		}
+	case <-ctx.Done():
+		// Stop the scan before giving up
+		cancelCtx, cancel := w.replyContext()
+		defer cancel()
+
+		w.bleCancel(cancelCtx)
+		w.pending.wait(cancelCtx, r)
+		return nil, contextError(ctx)
+	}
+
+	w.bleMutex.Lock()
+	defer w.bleMutex.Unlock()
+	return w.bleScanResults, nil
+}
+
+// BLECharacteristic is a characteristic of a BLE service.
+type BLECharacteristic struct {
+	UUID BLEUUID
+
+	// Properties is a combination of the BLEProperty flags
+	Properties uint8
+
+	// Value is the initial value of the characteristic. It isn't updated afterwards, see WINC.BLEValue.
+	Value []byte
+
+	// MaxLength is the longest value a central may write. Defaults to the length of Value or 20 bytes, whichever is
+	// larger, when zero.
+	MaxLength int
+
+	handle uint16
+	length int // MaxLength resolved when the service is added
+}
+
+// Handle returns the attribute handle of the value of the characteristic once the service is added.
+func (b *BLECharacteristic) Handle() uint16 {
+	return b.handle
+}
+
+func (b *BLECharacteristic) maxLength() int {
+	if b.MaxLength > 0 {
+		return b.MaxLength
+	} else if len(b.Value) > 20 {
+		return len(b.Value)
+	}
+
+	return 20
+}
+
+// BLEService is a primary service of the GATT server.
+type BLEService struct {
+	UUID            BLEUUID
+	Characteristics []*BLECharacteristic
+}
+
+// BLEWriteEvent is emitted when a central writes the value of a characteristic.
+type BLEWriteEvent struct {
+	Characteristic *BLECharacteristic
+	Value          []byte
+}
+
+// BLEConnectionEvent is emitted when a central connects or disconnects.
+type BLEConnectionEvent struct {
+	Address   net.HardwareAddr
+	Connected bool
+}
+
+// AddBLEService adds a service to the GATT server. Writes of centrals are reported by BLEWriteEvent on the event
+// channels.
+func (w *WINC) AddBLEService(ctx context.Context, service *BLEService) error {
+	w.bleCommandMutex.Lock()
+	defer w.bleCommandMutex.Unlock()
+
+	if err := w.bleSupported(); err != nil {
+		return err
+	}
+
+	// Every characteristic is declared by one attribute followed by its value and the client configuration, which
+	// centrals use to enable notifications.
+	type attribute struct {
+		uuid      BLEUUID
+		perm      uint16
+		maxLength uint16
+	}
+
+	var attributes []attribute
+	for _, c := range service.Characteristics {
+		if c.maxLength() > bleMaxMessageLength-bleHeaderLength-16 || len(c.Value) > c.maxLength() {
+			return ErrInvalidParameter
+		}
+
+		attributes = append(attributes,
+			attribute{uuid: bleUUIDCharacteristic, perm: BLEPropertyRead},
+			attribute{uuid: c.UUID, perm: uint16(c.Properties), maxLength: uint16(c.maxLength())})
+
+		if c.Properties&BLEPropertyNotify != 0 {
+			attributes = append(attributes, attribute{
+				uuid:      bleUUIDClientConfig,
+				perm:      BLEPropertyRead | BLEPropertyWrite,
+				maxLength: 2,
+			})
+		}
+	}
+
+	if 22+20*len(attributes) > bleMaxMessageLength-bleHeaderLength {
+		return ErrInvalidParameter
+	}
+
+	params := make([]byte, 22, 22+20*len(attributes))
+	binary.LittleEndian.PutUint16(params[2:], bleTaskApp)
+	params[5] = byte(len(attributes))
+	copy(params[6:], service.UUID[:])
+
+	for _, a := range attributes {
+		var buf [20]byte
+		copy(buf[:], a.uuid[:])
+		binary.LittleEndian.PutUint16(buf[16:], a.perm)
+		binary.LittleEndian.PutUint16(buf[18:], a.maxLength)
+		params = append(params, buf[:]...)
+	}
+
+	reply, err := w.bleRequest(ctx, bleRequestKey(bleGattmAddSvcRsp, 0), &bleMessage{
+		id:     bleGattmAddSvcReq,
+		dest:   bleTaskGattm,
+		src:    bleTaskApp,
+		params: params,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	rsp := reply.(*bleAddServiceReply)
+	if err = bleStatusError(rsp.status); err != nil {
+		return err
+	}
+
+	// The attributes follow the service declaration
+	handle := rsp.startHandle + 1
+	for _, c := range service.Characteristics {
+		c.handle = handle + 1
+		c.length = c.maxLength()
+		handle += 2
+		if c.Properties&BLEPropertyNotify != 0 {
+			handle++
+		}
+	}
+
+	w.bleMutex.Lock()
+	w.bleServices = append(w.bleServices, service)
+	w.bleMutex.Unlock()
+
+	for _, c := range service.Characteristics {
+		if len(c.Value) > 0 {
+			if err = w.bleSetValue(ctx, c, c.Value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type bleAddServiceReply struct {
+	startHandle uint16
+	status      byte
+}
+
+// SetBLEValue updates the value of a characteristic. Connected centrals are notified if the characteristic has
+// BLEPropertyNotify.
+func (w *WINC) SetBLEValue(ctx context.Context, characteristic *BLECharacteristic, value []byte) error {
+	w.bleCommandMutex.Lock()
+	defer w.bleCommandMutex.Unlock()
+
+	if err := w.bleSupported(); err != nil {
+		return err
+	}
+
+	// The handles of services added before a reset are gone
+	w.bleMutex.Lock()
+	added := w.bleAdded(characteristic)
+	w.bleMutex.Unlock()
+
+	if !added || len(value) > characteristic.length {
+		return ErrInvalidParameter
+	}
+
+	if err := w.bleSetValue(ctx, characteristic, value); err != nil {
+		return err
+	}
+
+	w.bleMutex.Lock()
+	connection, central := w.bleConnection, w.bleCentral
+	w.bleMutex.Unlock()
+
+	if central == nil || characteristic.Properties&BLEPropertyNotify == 0 {
+		return nil
+	}
+
+	params := make([]byte, 8, 8+len(value))
+	params[0] = bleOpNotify
+	binary.LittleEndian.PutUint16(params[4:], characteristic.handle)
+	binary.LittleEndian.PutUint16(params[6:], uint16(len(value)))
+
+	return w.bleCommand(&bleMessage{
+		id:     bleGattcSendEvtCmd,
+		dest:   connection<<8 | bleTaskGattc,
+		src:    bleTaskApp,
+		params: append(params, value...),
+	})
+}
+
+// bleSetValue stores the value of the characteristic in the GATT server.
+func (w *WINC) bleSetValue(ctx context.Context, characteristic *BLECharacteristic, value []byte) error {
+	params := make([]byte, 4, 4+len(value))
+	binary.LittleEndian.PutUint16(params[0:], characteristic.handle)
+	binary.LittleEndian.PutUint16(params[2:], uint16(len(value)))
+
+	reply, err := w.bleRequest(ctx, bleRequestKey(bleGattmAttSetValueRsp, 0), &bleMessage{
+		id:     bleGattmAttSetValueReq,
+		dest:   bleTaskGattm,
+		src:    bleTaskApp,
+		params: append(params, value...),
+	})
+
+	if err != nil {
+		return err
+	} else if err = bleStatusError(reply.(byte)); err != nil {
+		return err
+	}
+
+	w.bleMutex.Lock()
+	w.storeBLEValue(characteristic.handle, value)
+	w.bleMutex.Unlock()
+	return nil
+}
+
+// storeBLEValue keeps a copy of the value of the characteristic with the handle. The caller must hold bleMutex.
+func (w *WINC) storeBLEValue(handle uint16, value []byte) {
+	if w.bleValues == nil {
+		w.bleValues = map[uint16][]byte{}
+	}
+
+	w.bleValues[handle] = append([]byte(nil), value...)
+}
+
+// BLEValue returns the value of a characteristic of the GATT server, which is either the last value set by SetBLEValue
+// or written by a central. It returns nil if the service of the characteristic wasn't added.
+func (w *WINC) BLEValue(characteristic *BLECharacteristic) []byte {
+	w.bleMutex.Lock()
+	defer w.bleMutex.Unlock()
+
+	if !w.bleAdded(characteristic) {
+		return nil
+	}
+
+	return append([]byte(nil), w.bleValues[characteristic.handle]...)
+}
+
+// bleAdded reports whether the characteristic belongs to a service of the GATT server. The caller must hold bleMutex.
+func (w *WINC) bleAdded(characteristic *BLECharacteristic) bool {
+	return characteristic.handle != 0 && w.bleCharacteristic(characteristic.handle) == characteristic
+}
+
+// bleCharacteristic returns the characteristic whose value has the handle. The caller must hold bleMutex.
+func (w *WINC) bleCharacteristic(handle uint16) *BLECharacteristic {
+	for _, s := range w.bleServices {
+		for _, c := range s.Characteristics {
+			if c.handle == handle {
+				return c
+			}
+		}
+	}
+
+	return nil
+}
+
+// bleReset forgets the GATT server, which the firmware starts without.
+func (w *WINC) bleReset() {
+	w.bleMutex.Lock()
+	defer w.bleMutex.Unlock()
+
+	w.bleServices = nil
+	w.bleValues = nil
+	w.bleCentral = nil
+}
+
+// bleReceive receives a message of the BLE stack announced by OpcodeWifiRespBleApiRecv and handles it.
+func (w *WINC) bleReceive(address uint32, size uint16) (any, error) {
+	if size < 2+bleHeaderLength || size > 2+bleMaxMessageLength {
+		return nil, nil
+	}
+
+	data := make([]byte, size)
+	if err := w.hif.Receive(address, data, false); err != nil {
+		return nil, err
+	}
+
+	msg := &bleMessage{}
+	if !msg.read(data) {
+		return nil, nil
+	}
+
+	return w.bleCallback(msg)
+}
+
+// bleCallback handles a message of the BLE stack. The returned object is passed to the event channels.
+func (w *WINC) bleCallback(msg *bleMessage) (obj any, err error) {
+	switch msg.id {
+	case bleGapmCmpEvt:
+		if len(msg.params) < 2 {
+			return
+		}
+
+		// Cancelling an operation also completes the cancelled operation, which nobody waits for
+		w.pending.notify(bleRequestKey(bleGapmCmpEvt, msg.params[0]), msg.params[1])
+	case bleGapmAdvReportInd:
+		if len(msg.params) < 41 {
+			return
+		}
+
+		adv := &BLEAdvertisement{}
+		adv.read(msg.params)
+
+		w.bleMutex.Lock()
+		if w.bleScanning {
+			w.bleScanResults = append(w.bleScanResults, *adv)
+		}
+		w.bleMutex.Unlock()
+
+		obj = adv
+	case bleGattmAddSvcRsp:
+		if len(msg.params) < 3 {
+			return
+		}
+
+		w.pending.resolve(bleRequestKey(bleGattmAddSvcRsp, 0), &bleAddServiceReply{
+			startHandle: binary.LittleEndian.Uint16(msg.params[0:]),
+			status:      msg.params[2],
+		})
+	case bleGattmAttSetValueRsp:
+		if len(msg.params) < 3 {
+			return
+		}
+
+		w.pending.resolve(bleRequestKey(bleGattmAttSetValueRsp, 0), msg.params[2])
+	case bleGapcConnectionReqInd:
+		if len(msg.params) < 9 {
+			return
+		}
+
+		connection := msg.src >> 8
+		event := &BLEConnectionEvent{
+			Address:   bleAddress(msg.params[3:9]),
+			Connected: true,
+		}
+
+		w.bleMutex.Lock()
+		w.bleConnection = connection
+		w.bleCentral = event.Address
+		w.bleMutex.Unlock()
+
+		// The connection is dropped unless the host confirms it
+		err = w.bleSend(&bleMessage{
+			id:     bleGapcConnectionCfm,
+			dest:   connection<<8 | bleTaskGapc,
+			src:    bleTaskApp,
+			params: make([]byte, 4),
+		})
+
+		obj = event
+	case bleGapcDisconnectInd:
+		w.bleMutex.Lock()
+		obj = &BLEConnectionEvent{
+			Address: w.bleCentral,
+		}
+		w.bleCentral = nil
+		w.bleMutex.Unlock()
+	case bleGattcWriteReqInd:
+		if len(msg.params) < 6 {
+			return
+		}
+
+		handle := binary.LittleEndian.Uint16(msg.params[0:])
+		length := int(binary.LittleEndian.Uint16(msg.params[4:]))
+		if length > len(msg.params)-6 {
+			length = len(msg.params) - 6
+		}
+
+		value := append([]byte(nil), msg.params[6:6+length]...)
+
+		cfm := make([]byte, 4)
+		binary.LittleEndian.PutUint16(cfm[0:], handle)
+
+		w.bleMutex.Lock()
+		characteristic := w.bleCharacteristic(handle)
+		if characteristic == nil {
+			cfm[2] = bleAttInvalidHandle
+		} else if len(value) > characteristic.length {
+			cfm[2] = bleAttInvalidLength
+		} else {
+			w.storeBLEValue(handle, value)
+			obj = &BLEWriteEvent{
+				Characteristic: characteristic,
+				Value:          value,
+			}
+		}
+		w.bleMutex.Unlock()
+
+		err = w.bleSend(&bleMessage{
+			id:     bleGattcWriteCfm,
+			dest:   msg.src&0xFF00 | bleTaskGattc,
+			src:    bleTaskApp,
+			params: cfm,
+		})
+	}
+
+	return
+}
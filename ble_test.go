@@ -0,0 +1,234 @@
+//go:build winc_ble
+
+package winc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/simulator"
+)
+
+func TestBLE(t *testing.T) {
+	drv, _ := newTestDriver(t)
+	if err := drv.StartBLEAdvertising(context.Background(), BLEAdvertisingOptions{}); !errors.Is(err, ErrBLEUnsupported) {
+		t.Fatalf("Expected %v on a WINC1500, got %v", ErrBLEUnsupported, err)
+	}
+
+	drv, chip := newWINC3400TestDriver(t)
+	events := drv.OpenEventChannel()
+	ctx := context.Background()
+
+	serviceUUID, err := ParseBLEUUID("6e400001-b5a3-f393-e0a9-e50e24dcca9e")
+	if err != nil {
+		t.Fatalf("ParseBLEUUID: %v", err)
+	} else if s := serviceUUID.String(); s != "6e400001-b5a3-f393-e0a9-e50e24dcca9e" {
+		t.Errorf("Expected the UUID to round trip, got %v", s)
+	}
+
+	credentials := &BLECharacteristic{
+		UUID:       NewBLEUUID16(0xFF01),
+		Properties: BLEPropertyWrite,
+		MaxLength:  100,
+	}
+
+	status := &BLECharacteristic{
+		UUID:       NewBLEUUID16(0xFF02),
+		Properties: BLEPropertyRead | BLEPropertyNotify,
+		Value:      []byte("idle"),
+	}
+
+	if err = drv.AddBLEService(ctx, &BLEService{
+		UUID:            serviceUUID,
+		Characteristics: []*BLECharacteristic{credentials, status},
+	}); err != nil {
+		t.Fatalf("AddBLEService: %v", err)
+	}
+
+	// The service declaration is followed by the declaration and value of each characteristic
+	if credentials.Handle() != 3 || status.Handle() != 5 {
+		t.Errorf("Expected handles 3 and 5, got %v and %v", credentials.Handle(), status.Handle())
+	}
+
+	if value := chip.ReadBLE(status.Handle()); !bytes.Equal(value, status.Value) {
+		t.Errorf("Expected %q, got %q", status.Value, value)
+	} else if value = drv.BLEValue(status); !bytes.Equal(value, status.Value) {
+		t.Errorf("Expected the driver to report %q, got %q", status.Value, value)
+	}
+
+	advertising := []struct {
+		name     string
+		options  BLEAdvertisingOptions
+		expected error
+	}{
+		{"data too long", BLEAdvertisingOptions{Data: make([]byte, 32)}, ErrInvalidParameter},
+		{"scan response too long", BLEAdvertisingOptions{ScanResponse: make([]byte, 32)}, ErrInvalidParameter},
+		{"interval too short", BLEAdvertisingOptions{Interval: time.Millisecond}, ErrInvalidParameter},
+		{"non-connectable", BLEAdvertisingOptions{Data: []byte{0x02, 0x01, 0x06}}, nil},
+	}
+
+	for _, test := range advertising {
+		t.Run(test.name, func(t *testing.T) {
+			advertising := make(chan error, 1)
+			go func() {
+				advertising <- drv.StartBLEAdvertising(ctx, test.options)
+			}()
+
+			if test.expected != nil {
+				if err := <-advertising; !errors.Is(err, test.expected) {
+					t.Fatalf("Expected %v, got %v", test.expected, err)
+				}
+
+				return
+			}
+
+			waitFor(t, func() bool {
+				_, _, active := chip.BLEAdvertising()
+				return active
+			})
+
+			if data, _, _ := chip.BLEAdvertising(); !bytes.Equal(data, test.options.Data) {
+				t.Errorf("Expected advertising of %x, got %x", test.options.Data, data)
+			}
+
+			if err := drv.StopBLEAdvertising(ctx); err != nil {
+				t.Fatalf("StopBLEAdvertising: %v", err)
+			}
+
+			if err := <-advertising; !errors.Is(err, ErrBLEAdvertisingStopped) {
+				t.Errorf("Expected %v, got %v", ErrBLEAdvertisingStopped, err)
+			}
+
+			if _, _, active := chip.BLEAdvertising(); active {
+				t.Error("Expected advertising to stop")
+			}
+		})
+	}
+
+	// Nothing is left to stop
+	if err = drv.StopBLEAdvertising(ctx); err != nil {
+		t.Errorf("StopBLEAdvertising: %v", err)
+	}
+
+	// Advertising ends with the context
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	if err = drv.StartBLEAdvertising(timeoutCtx, BLEAdvertisingOptions{}); !errors.Is(err, ErrOperationTimeout) {
+		t.Errorf("Expected %v, got %v", ErrOperationTimeout, err)
+	} else if _, _, active := chip.BLEAdvertising(); active {
+		t.Error("Expected advertising to stop with the context")
+	}
+
+	chip.AddBLEPeripheral(simulator.BLEPeripheral{
+		Address: net.HardwareAddr{0xC0, 0x01, 0x02, 0x03, 0x04, 0x05},
+		RSSI:    -60,
+		Data:    []byte{0x02, 0x01, 0x06},
+	})
+
+	results, err := drv.ScanBLE(ctx, BLEScanOptions{Duration: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("ScanBLE: %v", err)
+	} else if len(results) != 1 {
+		t.Fatalf("Expected 1 advertisement, got %d", len(results))
+	} else if results[0].Address.String() != "c0:01:02:03:04:05" || results[0].RSSI != -60 {
+		t.Errorf("Unexpected advertisement %+v", results[0])
+	}
+
+	// A phone connects and writes the credentials
+	connecting := make(chan error, 1)
+	go func() {
+		connecting <- drv.StartBLEAdvertising(ctx, BLEAdvertisingOptions{Connectable: true})
+	}()
+
+	central := net.HardwareAddr{0x4A, 0x10, 0x20, 0x30, 0x40, 0x50}
+	waitFor(t, func() bool {
+		return chip.ConnectBLE(central)
+	})
+
+	if err = <-connecting; err != nil {
+		t.Fatalf("StartBLEAdvertising: %v", err)
+	}
+
+	e := waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*BLEConnectionEvent)
+		return ok
+	})
+
+	if c := e.Data.(*BLEConnectionEvent); !c.Connected || c.Address.String() != central.String() {
+		t.Errorf("Unexpected connection event %+v", c)
+	} else if !chip.BLEConnected() {
+		t.Error("Expected the connection to be confirmed")
+	}
+
+	chip.WriteBLE(credentials.Handle(), []byte("winc:password"))
+	e = waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*BLEWriteEvent)
+		return ok
+	})
+
+	if w := e.Data.(*BLEWriteEvent); w.Characteristic != credentials || string(w.Value) != "winc:password" {
+		t.Errorf("Unexpected write event %+v", w)
+	} else if value := drv.BLEValue(credentials); string(value) != "winc:password" {
+		t.Errorf("Expected the driver to report the write, got %q", value)
+	} else if credentials.Value != nil {
+		t.Errorf("Expected the characteristic to be left alone, got %q", credentials.Value)
+	}
+
+	if err = drv.SetBLEValue(ctx, status, []byte("connected")); err != nil {
+		t.Fatalf("SetBLEValue: %v", err)
+	}
+
+	if value := chip.ReadBLE(credentials.Handle()); string(value) != "winc:password" {
+		t.Errorf("Expected the write to be stored, got %q", value)
+	}
+
+	if n := chip.BLENotifications(); len(n) != 1 || string(n[0]) != "connected" {
+		t.Errorf("Expected a notification of the status, got %q", n)
+	} else if value := drv.BLEValue(status); string(value) != "connected" {
+		t.Errorf("Expected the driver to report the new status, got %q", value)
+	}
+
+	// The GATT server starts empty after a reset
+	drv.Reset()
+	if err = drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	if err = drv.SetBLEValue(ctx, status, []byte("reset")); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected %v after a reset, got %v", ErrInvalidParameter, err)
+	} else if value := chip.ReadBLE(status.Handle()); value != nil {
+		t.Errorf("Expected the GATT server to stay empty, got %q", value)
+	}
+}
+
+func TestBLEScanReset(t *testing.T) {
+	drv, _ := newWINC3400TestDriver(t)
+
+	scanning := make(chan error, 1)
+	go func() {
+		_, err := drv.ScanBLE(context.Background(), BLEScanOptions{Duration: 10 * time.Second})
+		scanning <- err
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	drv.Reset()
+
+	select {
+	case err := <-scanning:
+		if err != net.ErrClosed {
+			t.Errorf("Expected %v, got %v", net.ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scan to stop")
+	}
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Command hifdump decodes a HIF trace recorded with the trace package.
+//
+// Usage:
+//
+//	hifdump [-json] [-spi] [trace file]
+//
+// The trace is read from stdin if no file is given. By default, only the HIF frames are printed. The -spi flag also
+// prints the raw SPI transfers and interrupts the frames were built from.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	winc "github.com/waj334/tinygo-winc"
+	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/trace"
+)
+
+// hexBytes is encoded as a hex string in JSON output
+type hexBytes []byte
+
+func (h hexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+type entry struct {
+	// Time is the time since the start of the trace in nanoseconds
+	Time      int64    `json:"time"`
+	Kind      string   `json:"kind"`
+	Group     string   `json:"group,omitempty"`
+	Opcode    string   `json:"opcode,omitempty"`
+	Length    uint16   `json:"length,omitempty"`
+	Offset    uint16   `json:"offset,omitempty"`
+	Address   uint32   `json:"address,omitempty"`
+	Control   hexBytes `json:"control,omitempty"`
+	Data      hexBytes `json:"data,omitempty"`
+	Done      bool     `json:"done,omitempty"`
+	Write     hexBytes `json:"write,omitempty"`
+	Read      hexBytes `json:"read,omitempty"`
+	ReplyType string   `json:"replyType,omitempty"`
+	Reply     any      `json:"reply,omitempty"`
+}
+
+// decoder tracks the frame that is currently being received so that its payload can be parsed
+type decoder struct {
+	group   protocol.GroupId
+	opcode  protocol.OpcodeId
+	payload uint32
+	pending bool
+}
+
+func (d *decoder) decode(record trace.Record) entry {
+	e := entry{
+		Time: int64(record.Time),
+		Kind: record.Kind.String(),
+	}
+
+	switch record.Kind {
+	case trace.KindTx, trace.KindTransfer:
+		e.Write = record.Write
+		e.Read = record.Read
+	case trace.KindSend:
+		e.Group = winc.GroupName(record.Group)
+		e.Opcode = winc.OpcodeName(record.Group, record.Opcode)
+		e.Offset = record.Offset
+		e.Control = record.Control
+		e.Data = record.Data
+	case trace.KindIsr:
+		e.Group = winc.GroupName(record.Group)
+		e.Opcode = winc.OpcodeName(record.Group, record.Opcode)
+		e.Length = record.Length
+		e.Address = record.Address
+
+		// Callbacks receive the payload following the HIF header
+		d.group = record.Group
+		d.opcode = record.Opcode
+		d.payload = record.Address + protocol.HifHdrOffset
+		d.pending = true
+	case trace.KindReceive:
+		e.Address = record.Address
+		e.Data = record.Data
+		e.Done = record.Done
+
+		// The first block read from the start of the payload holds the reply struct
+		if d.pending && record.Address == d.payload && record.Data != nil {
+			e.Group = winc.GroupName(d.group)
+			e.Opcode = winc.OpcodeName(d.group, d.opcode)
+			if reply := winc.DecodeReply(d.group, d.opcode, record.Data); reply != nil {
+				e.Reply = reply
+				e.ReplyType = reflect.TypeOf(reply).Elem().Name()
+			}
+			d.pending = false
+		}
+	}
+
+	return e
+}
+
+func (e *entry) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%12.6f %-9s", float64(e.Time)/1e9, e.Kind)
+
+	switch e.Kind {
+	case "tx", "transfer":
+		if e.Write != nil {
+			fmt.Fprintf(&b, " write=% x", []byte(e.Write))
+		}
+		if e.Read != nil {
+			fmt.Fprintf(&b, " read=% x", []byte(e.Read))
+		}
+	case "send":
+		fmt.Fprintf(&b, " %s %s control=%d data=%d offset=%d", e.Group, e.Opcode, len(e.Control), len(e.Data), e.Offset)
+	case "isr":
+		fmt.Fprintf(&b, " %s %s length=%d address=%#x", e.Group, e.Opcode, e.Length, e.Address)
+	case "receive":
+		fmt.Fprintf(&b, " address=%#x length=%d", e.Address, len(e.Data))
+		if e.Done {
+			b.WriteString(" done")
+		}
+		if e.Reply != nil {
+			fmt.Fprintf(&b, " %s%+v", e.ReplyType, reflect.ValueOf(e.Reply).Elem().Interface())
+		}
+	}
+
+	return b.String()
+}
+
+func main() {
+	asJSON := flag.Bool("json", false, "print one JSON object per record")
+	spi := flag.Bool("spi", false, "include SPI transfers and interrupts")
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if err := dump(os.Stdout, bufio.NewReader(in), *asJSON, *spi); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func dump(w io.Writer, r io.Reader, asJSON, spi bool) error {
+	reader, err := trace.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	encoder := json.NewEncoder(out)
+	d := decoder{}
+
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch record.Kind {
+		case trace.KindTx, trace.KindTransfer, trace.KindInterrupt:
+			if !spi {
+				continue
+			}
+		}
+
+		e := d.decode(record)
+		if asJSON {
+			if err = encoder.Encode(&e); err != nil {
+				return err
+			}
+		} else {
+			fmt.Fprintln(out, e.String())
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	winc "github.com/waj334/tinygo-winc"
+	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/simulator"
+	"github.com/waj334/tinygo-winc/trace"
+)
+
+// record returns a trace of the driver connecting to a simulated network
+func record(t *testing.T) []byte {
+	chip := simulator.New()
+	chip.AddNetwork("winc", "password")
+
+	var buf bytes.Buffer
+	recorder, err := trace.NewRecorder(&buf, chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv := &winc.WINC{
+		SPI:       recorder,
+		CS:        chip.CS(),
+		IRQ:       recorder.InterruptPin(chip.IRQ()),
+		EnablePin: chip.EnablePin(),
+		ResetPin:  chip.ResetPin(),
+		Tracer:    recorder,
+	}
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := drv.OpenEventChannel()
+	if err := drv.WifiConnectPsk(winc.WifiConnectionSettings{
+		Ssid:       "winc",
+		Passphrase: "password",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if _, ok := e.Data.(*winc.IpConfig); ok {
+				// Stop the driver before the trace is read
+				drv.Reset()
+				return buf.Bytes()
+			}
+		case <-timeout:
+			drv.Reset()
+			t.Fatal("timed out waiting for IP configuration")
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	data := record(t)
+
+	tests := []struct {
+		name     string
+		json     bool
+		spi      bool
+		expected []string
+	}{
+		{
+			name: "text",
+			expected: []string{
+				"send      WIFI OpcodeWifiReqConn",
+				"isr       WIFI OpcodeWifiRespConStateChanged",
+				"WifiStateChanged{CurrentState:1 ErrorCode:0}",
+			},
+		},
+		{
+			name: "spi",
+			spi:  true,
+			expected: []string{
+				"interrupt",
+				"transfer ",
+			},
+		},
+		{
+			name: "json",
+			json: true,
+			expected: []string{
+				`"opcode":"OpcodeWifiRespConStateChanged"`,
+				`"replyType":"WifiStateChanged","reply":{"CurrentState":1,"ErrorCode":0}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := dump(&out, bytes.NewReader(data), tt.json, tt.spi); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, s := range tt.expected {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("Expected output to contain %q", s)
+				}
+			}
+
+			if tt.json {
+				for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+					if !json.Valid([]byte(line)) {
+						t.Errorf("Invalid JSON: %s", line)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReplyJSON(t *testing.T) {
+	provisionInfo := make([]byte, 100)
+	copy(provisionInfo, "winc")
+	provisionInfo[98] = byte(winc.WifiSecurityWpaPsk)
+	provisionInfo[99] = 1
+
+	tests := []struct {
+		name     string
+		opcode   protocol.OpcodeId
+		data     []byte
+		expected string
+	}{
+		{"default connect", winc.OpcodeWifiRespDefaultConnect, []byte{0xE8, 0, 0, 0}, `{"ErrorCode":-24}`},
+		{"provision info", winc.OpcodeWifiRespProvisionInfo, provisionInfo,
+			`{"SSID":"winc","Passphrase":"","SecurityType":2,"Status":1}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := json.Marshal(winc.DecodeReply(winc.GroupWIFI, tt.opcode, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			} else if string(b) != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, b)
+			}
+		})
+	}
+}
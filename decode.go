@@ -0,0 +1,214 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"fmt"
+
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+var groupNames = [...]string{
+	GroupMain:     "MAIN",
+	GroupWIFI:     "WIFI",
+	GroupIP:       "IP",
+	GroupHIF:      "HIF",
+	GroupOTA:      "OTA",
+	GroupSSL:      "SSL",
+	GroupCrypto:   "CRYPTO",
+	GroupSigma:    "SIGMA",
+	GroupInternal: "INTERNAL",
+}
+
+var wifiOpcodeNames = map[protocol.OpcodeId]string{
+	OpcodeWifiReqSetMacAddress:      "OpcodeWifiReqSetMacAddress",
+	OpcodeWifiReqGetConnInfo:        "OpcodeWifiReqGetConnInfo",
+	OpcodeWifiRespConnInfo:          "OpcodeWifiRespConnInfo",
+	OpcodeWifiReqSetDeviceName:      "OpcodeWifiReqSetDeviceName",
+	OpcodeWifiReqStartProvisionMode: "OpcodeWifiReqStartProvisionMode",
+	OpcodeWifiRespProvisionInfo:     "OpcodeWifiRespProvisionInfo",
+	OpcodeWifiReqStopProvisionMode:  "OpcodeWifiReqStopProvisionMode",
+	OpcodeWifiReqScan:               "OpcodeWifiReqScan",
+	OpcodeWifiRespScanDone:          "OpcodeWifiRespScanDone",
+	OpcodeWifiReqScanResult:         "OpcodeWifiReqScanResult",
+	OpcodeWifiRespScanResult:        "OpcodeWifiRespScanResult",
+	OpcodeWifiReqPassiveScan:        "OpcodeWifiReqPassiveScan",
+	OpcodeWifiReqEnableSntp:         "OpcodeWifiReqEnableSntp",
+	OpcodeWifiReqDisableSntp:        "OpcodeWifiReqDisableSntp",
+	OpcodeWifiReqGetSysTime:         "OpcodeWifiReqGetSysTime",
+	OpcodeWifiRespGetSysTime:        "OpcodeWifiRespGetSysTime",
+	OpcodeWifiReqConfigSntp:         "OpcodeWifiReqConfigSntp",
+	OpcodeWifiRespBleApiRecv:        "OpcodeWifiRespBleApiRecv",
+	OpcodeWifiReqBleApiSend:         "OpcodeWifiReqBleApiSend",
+	OpcodeWifiReqConnect:            "OpcodeWifiReqConnect",
+	OpcodeWifiReqDefaultConnect:     "OpcodeWifiReqDefaultConnect",
+	OpcodeWifiRespDefaultConnect:    "OpcodeWifiRespDefaultConnect",
+	OpcodeWifiReqDisconnect:         "OpcodeWifiReqDisconnect",
+	OpcodeWifiRespConStateChanged:   "OpcodeWifiRespConStateChanged",
+	OpcodeWifiReqSleep:              "OpcodeWifiReqSleep",
+	OpcodeWifiReqWpsScan:            "OpcodeWifiReqWpsScan",
+	OpcodeWifiReqWps:                "OpcodeWifiReqWps",
+	OpcodeWifiReqStartWps:           "OpcodeWifiReqStartWps",
+	OpcodeWifiReqDisableWps:         "OpcodeWifiReqDisableWps",
+	OpcodeWifiReqDhcpConf:           "OpcodeWifiReqDhcpConf",
+	OpcodeWifiRespIpConfigured:      "OpcodeWifiRespIpConfigured",
+	OpcodeWifiRespIpConflict:        "OpcodeWifiRespIpConflict",
+	OpcodeWifiReqEnableMonitoring:   "OpcodeWifiReqEnableMonitoring",
+	OpcodeWifiReqDisableMonitoring:  "OpcodeWifiReqDisableMonitoring",
+	OpcodeWifiRespWifiRxPacket:      "OpcodeWifiRespWifiRxPacket",
+	OpcodeWifiReqSendWifiPacket:     "OpcodeWifiReqSendWifiPacket",
+	OpcodeWifiReqLsnInt:             "OpcodeWifiReqLsnInt",
+	OpcodeWifiReqDoze:               "OpcodeWifiReqDoze",
+	OpcodeWifiReqConn:               "OpcodeWifiReqConn",
+	OpcodeWifiIndConnParam:          "OpcodeWifiIndConnParam",
+	OpcodeWifiReqDhcpFailure:        "OpcodeWifiReqDhcpFailure",
+	OpcodeWifiReqDeleteApId:         "OpcodeWifiReqDeleteApId",
+	OpcodeWifiReqEnableAP:           "OpcodeWifiReqEnableAP",
+	OpcodeWifiReqDisableAP:          "OpcodeWifiReqDisableAP",
+}
+
+var socketOpcodeNames = map[protocol.OpcodeId]string{
+	OpcodeIpReqStaticIpConf:     "OpcodeIpReqStaticIpConf",
+	OpcodeIpReqEnableDhcp:       "OpcodeIpReqEnableDhcp",
+	OpcodeIpReqDisableDhcp:      "OpcodeIpReqDisableDhcp",
+	OpcodeSocketInvalid:         "OpcodeSocketInvalid",
+	OpcodeSocketBind:            "OpcodeSocketBind",
+	OpcodeSocketListen:          "OpcodeSocketListen",
+	OpcodeSocketAccept:          "OpcodeSocketAccept",
+	OpcodeSocketConnect:         "OpcodeSocketConnect",
+	OpcodeSocketSend:            "OpcodeSocketSend",
+	OpcodeSocketRecv:            "OpcodeSocketRecv",
+	OpcodeSocketSendTo:          "OpcodeSocketSendTo",
+	OpcodeSocketRecvFrom:        "OpcodeSocketRecvFrom",
+	OpcodeSocketClose:           "OpcodeSocketClose",
+	OpcodeSocketDnsResolve:      "OpcodeSocketDnsResolve",
+	OpcodeSocketSslConnect:      "OpcodeSocketSslConnect",
+	OpcodeSocketSslSend:         "OpcodeSocketSslSend",
+	OpcodeSocketSslRecv:         "OpcodeSocketSslRecv",
+	OpcodeSocketSslClose:        "OpcodeSocketSslClose",
+	OpcodeSocketSetSocketOption: "OpcodeSocketSetSocketOption",
+	OpcodeSocketSslCreate:       "OpcodeSocketSslCreate",
+	OpcodeSocketSslSetSockOpt:   "OpcodeSocketSslSetSockOpt",
+	OpcodeSocketPing:            "OpcodeSocketPing",
+	OpcodeSocketSslSetCsList:    "OpcodeSocketSslSetCsList",
+	OpcodeSocketSslBind:         "OpcodeSocketSslBind",
+	OpcodeSocketSslExpCheck:     "OpcodeSocketSslExpCheck",
+	OpcodeSocketSecure:          "OpcodeSocketSecure",
+	OpcodeSocketSslConnectAlpn:  "OpcodeSocketSslConnectAlpn",
+}
+
+var sslOpcodeNames = map[protocol.OpcodeId]string{
+	sslReqCertificateVerify:              "sslReqCertificateVerify",
+	sslRequestEcc:                        "sslRequestEcc",
+	sslResponseEcc:                       "sslResponseEcc",
+	sslIndicateCertificateRevocationList: "sslIndicateCertificateRevocationList",
+	sslRequestWriteOwnCertificates:       "sslRequestWriteOwnCertificates",
+	sslRequestSetCipherSuiteList:         "sslRequestSetCipherSuiteList",
+	sslResponseSetCipherSuiteList:        "sslResponseSetCipherSuiteList",
+	sslRespondWriteOwnCertificates:       "sslRespondWriteOwnCertificates",
+}
+
+// GroupName returns the name of the HIF group.
+func GroupName(group protocol.GroupId) string {
+	if int(group) < len(groupNames) {
+		return groupNames[group]
+	}
+
+	return fmt.Sprintf("GROUP(%d)", group)
+}
+
+// OpcodeName returns the name of the opcode within the HIF group. The data packet bit of request opcodes is ignored.
+func OpcodeName(group protocol.GroupId, opcode protocol.OpcodeId) string {
+	opcode &^= protocol.OpcodeReqDataPkt
+
+	var names map[protocol.OpcodeId]string
+	switch group {
+	case GroupWIFI:
+		names = wifiOpcodeNames
+	case GroupIP:
+		names = socketOpcodeNames
+	case GroupSSL:
+		names = sslOpcodeNames
+	}
+
+	if name, ok := names[opcode]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OPCODE(%#02x)", uint8(opcode))
+}
+
+// DecodeReply parses the payload of a HIF frame sent by the chip into the respective reply struct. It returns nil if
+// the frame is unknown or the payload is too short.
+func DecodeReply(group protocol.GroupId, opcode protocol.OpcodeId, data []byte) any {
+	var size int
+	var reply interface{ read([]byte) }
+
+	switch group {
+	case GroupWIFI:
+		switch opcode {
+		case OpcodeWifiRespGetSysTime:
+			size, reply = 8, &SystemTime{}
+		case OpcodeWifiReqDhcpConf:
+			size, reply = 24, &IpConfig{}
+		case OpcodeWifiRespConStateChanged:
+			size, reply = 4, &WifiStateChanged{}
+		case OpcodeWifiRespConnInfo:
+			size, reply = 48, &WifiConnectionInfo{}
+		case OpcodeWifiRespScanResult:
+			size, reply = 44, &ScanResult{}
+		case OpcodeWifiRespIpConflict:
+			size, reply = 4, &IPConflictError{}
+		case OpcodeWifiRespDefaultConnect:
+			size, reply = 4, &defaultConnectReply{}
+		case OpcodeWifiReqWps:
+			size, reply = 100, &WPSCredentials{}
+		case OpcodeWifiRespProvisionInfo:
+			size, reply = 100, &ProvisionInfo{}
+		}
+	case GroupIP:
+		switch opcode {
+		case OpcodeSocketAccept:
+			size, reply = 12, &AcceptReply{}
+		case OpcodeSocketBind, OpcodeSocketSslBind:
+			size, reply = 4, &BindReply{}
+		case OpcodeSocketConnect, OpcodeSocketSslConnect:
+			size, reply = 4, &ConnectReply{}
+		case OpcodeSocketListen:
+			size, reply = 4, &ListenReply{}
+		case OpcodeSocketRecv, OpcodeSocketSslRecv, OpcodeSocketRecvFrom:
+			size, reply = 16, &RecvReply{}
+		case OpcodeSocketSend, OpcodeSocketSslSend, OpcodeSocketSendTo:
+			size, reply = 8, &SendReply{}
+		}
+	}
+
+	if reply == nil || len(data) < size {
+		return nil
+	}
+
+	reply.read(data)
+	return reply
+}
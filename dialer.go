@@ -11,11 +11,17 @@ import (
 )
 
 func (w *WINC) Dial(network, address string) (conn net.Conn, err error) {
-	return w.DialContext(context.Background(), network, address, false)
+	ctx, cancel := w.replyContext()
+	defer cancel()
+
+	return w.DialContext(ctx, network, address, false)
 }
 
 func (w *WINC) DialTLS(network, address string) (conn net.Conn, err error) {
-	return w.DialContext(context.Background(), network, address, true)
+	ctx, cancel := w.replyContext()
+	defer cancel()
+
+	return w.DialContext(ctx, network, address, true)
 }
 
 func (w *WINC) DialContext(ctx context.Context, network, address string, tls bool) (conn net.Conn, err error) {
@@ -33,7 +39,7 @@ func (w *WINC) DialContext(ctx context.Context, network, address string, tls boo
 	port, _ := strconv.Atoi(uri.Port())
 
 	// Perform DNS lookup
-	if ip, err = w.GetHostByName(uri.Hostname()); err != nil {
+	if ip, err = w.GetHostByNameContext(ctx, uri.Hostname()); err != nil {
 		return nil, err
 	}
 
@@ -80,7 +86,7 @@ func (w *WINC) DialContext(ctx context.Context, network, address string, tls boo
 	}
 
 	// Connect to the url
-	if err = socket.Connect(addr); err != nil {
+	if err = socket.ConnectContext(ctx, addr); err != nil {
 		// Free the socket
 		socket.Shutdown()
 
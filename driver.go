@@ -27,6 +27,7 @@ package winc
 import (
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tinygo.org/x/drivers"
@@ -37,16 +38,48 @@ import (
 )
 
 type WINC struct {
-	SPI       drivers.SPI
-	CS        hal.Pin
+	SPI drivers.SPI
+	CS  hal.Pin
+	// IRQ may be nil on boards that don't route the interrupt line of the chip. The driver polls the chip then, which
+	// wakes it up every time. See PowerSavePollInterval.
 	IRQ       hal.InterruptPin
 	EnablePin hal.Pin
 	ResetPin  hal.Pin
 
 	EccProvider EccProvider
 
-	wifiState WifiState
-	ipAddr    net.IPNet
+	// Tracer observes all HIF transactions when set before initialization
+	Tracer protocol.Tracer
+
+	// DataPacketSize is the size of the SPI data packets. Defaults to 1024 bytes when zero.
+	DataPacketSize int
+
+	// ReplyTimeout bounds how long calls that don't accept a context wait for the reply of the firmware. These calls
+	// wait indefinitely when zero.
+	ReplyTimeout time.Duration
+
+	// MonitorBufferLength is the number of captured frames buffered in monitor mode. Defaults to 16 when zero.
+	MonitorBufferLength int
+
+	// PollInterval is how often the chip is checked for packets when IRQ is nil. Defaults to 10 ms when zero.
+	PollInterval time.Duration
+
+	// PowerSavePollInterval replaces PollInterval while a power-save mode is selected and no reply is awaited. Every
+	// check keeps the chip from dozing, so this trades the latency of unsolicited events like incoming data for power.
+	// Defaults to 1 s when zero.
+	PowerSavePollInterval time.Duration
+
+	wifiState         WifiState
+	storedNetworks    []string        // See StoredNetworks
+	storingNetwork    string          // Network whose credentials are stored once connected
+	defaultConnecting bool            // Set while ConnectDefault awaits the connection
+	stateChannels     []chan struct{} // See openStateChannel
+	stateMutex        sync.Mutex
+	powerSaveMode     PowerSaveMode
+	powerSaving       uint32 // Set while powerSaveMode isn't PowerSaveNone. Read atomically by the ISR routine.
+	ipAddr            net.IPNet
+	ipResult          any // *IpConfig or the error of the last address assignment
+	ipMutex           sync.Mutex
 
 	hif protocol.Hif
 
@@ -54,13 +87,29 @@ type WINC struct {
 	isrSignal         chan bool
 	isrShutdownSignal chan bool
 
-	callbackChan chan any
+	pending      pendingRequests
+	sslReplyChan chan any
+
+	monitorChan    chan *MonitorFrame
+	monitorChannel WifiChannel
+	monitorDropped uint32
+	monitorMutex   sync.Mutex
+
+	sntpStop     chan struct{} // See ConfigureSNTP
+	sntpDisabled bool
+
+	bleState // Empty unless built with the winc_ble tag
 
 	sockets             [maxSocket]*Socket
 	sessionCounterMutex sync.Mutex
 	sessionCounter      uint16
 	SocketBufferLength  int
 
+	scanMutex sync.Mutex
+
+	// mutex serializes the commands of the public calls. Calls that wait for a reply only hold it while sending, see
+	// request, and calls that must not overlap use a mutex of their own, like scanMutex. The interrupt callbacks never
+	// take it since Reset holds it while stopping the ISR.
 	mutex sync.Mutex
 }
 
@@ -71,6 +120,13 @@ func (w *WINC) Initialize() (err error) {
 	if !w.initialized {
 		// Create the hardware interface abstraction layer
 		w.hif = protocol.CreateHif(w.SPI, w.CS)
+		if w.Tracer != nil {
+			w.hif.SetTracer(w.Tracer)
+		}
+
+		if w.DataPacketSize != 0 {
+			w.hif.SetDataPacketSize(w.DataPacketSize)
+		}
 
 		// Initialize the HAL
 		err = w.hif.Init()
@@ -78,6 +134,14 @@ func (w *WINC) Initialize() (err error) {
 			return
 		}
 
+		// Refuse firmware that speaks another host interface version
+		var info *FirmwareInfo
+		if info, err = w.FirmwareInfo(); err != nil {
+			return
+		} else if err = info.compatible(); err != nil {
+			return
+		}
+
 		// Register interrupt callbacks
 		w.hif.RegisterCallback(GroupWIFI, w.wifiCallback)
 		w.hif.RegisterCallback(GroupIP, w.socketCallback)
@@ -86,13 +150,14 @@ func (w *WINC) Initialize() (err error) {
 		// Start the interrupt service (go)routine
 		w.isrSignal = make(chan bool, 1)
 		w.isrShutdownSignal = make(chan bool, 1)
-		go w.isr(w.isrSignal)
+		go w.isr(w.isrSignal, w.pollInterval(), w.powerSavePollInterval())
 
 		// Enable the interrupt
 		w.setInterruptEnabled(true)
 
 		// Create the callback channel
-		w.callbackChan = make(chan any, 1)
+		w.pending.reset()
+		w.sslReplyChan = make(chan any, 1)
 
 		// set up sockets
 		w.sessionCounter = 1
@@ -111,6 +176,20 @@ func (w *WINC) OpenEventChannel() <-chan protocol.Event {
 	return w.hif.OpenEventChannel()
 }
 
+// CloseEventChannel closes a channel returned by OpenEventChannel. Event channels are also closed by Reset.
+func (w *WINC) CloseEventChannel(c <-chan protocol.Event) {
+	w.hif.CloseEventChannel(c)
+}
+
+// localAddr returns the address of the station.
+func (w *WINC) localAddr() net.Addr {
+	w.ipMutex.Lock()
+	defer w.ipMutex.Unlock()
+
+	addr := w.ipAddr
+	return &addr
+}
+
 // Reset the SoC by driving chip enable and reset pins low then high
 func (w *WINC) Reset() {
 	w.mutex.Lock()
@@ -138,7 +217,44 @@ func (w *WINC) Reset() {
 	// Shutdown driver.hif
 	w.hif.Shutdown()
 
-	// Reset sockets
+	// The firmware starts with SNTP enabled and its default server
+	w.stopSNTPResync()
+	w.sntpDisabled = false
+
+	// Drop the outstanding requests and wake their callers
+	w.pending.reset()
+
+	w.monitorMutex.Lock()
+	if w.monitorChan != nil {
+		close(w.monitorChan)
+		w.monitorChan = nil
+	}
+	w.monitorMutex.Unlock()
+
+	w.ipMutex.Lock()
+	w.ipResult = nil
+	w.ipAddr = net.IPNet{}
+	w.ipMutex.Unlock()
+
+	w.stateMutex.Lock()
+	for _, c := range w.stateChannels {
+		close(c)
+	}
+	w.stateChannels = nil
+	w.stateMutex.Unlock()
+
+	w.bleReset()
+
+	// The firmware starts without power-save
+	w.powerSaveMode = PowerSaveNone
+	atomic.StoreUint32(&w.powerSaving, 0)
+
+	// Reset sockets and wake the callers waiting for connections
+	for _, socket := range w.sockets {
+		if socket != nil {
+			close(socket.closed)
+		}
+	}
 	w.sockets = [maxSocket]*Socket{}
 	//currentSocket = nil
 
@@ -172,6 +288,11 @@ func (w *WINC) GetGPIOState(gpio GPIOType) (GPIOState, error) {
 }
 
 func (w *WINC) setInterruptEnabled(on bool) {
+	if w.IRQ == nil {
+		// The ISR routine polls the chip instead
+		return
+	}
+
 	if on {
 		w.IRQ.Enable(w.irqHandler)
 	} else {
@@ -179,10 +300,40 @@ func (w *WINC) setInterruptEnabled(on bool) {
 	}
 }
 
-func (w *WINC) isr(signal <-chan bool) {
+// pollInterval returns the interval the ISR routine polls the chip at. It is zero if the interrupt line is used.
+func (w *WINC) pollInterval() time.Duration {
+	if w.IRQ != nil {
+		return 0
+	} else if w.PollInterval > 0 {
+		return w.PollInterval
+	}
+
+	return 10 * time.Millisecond
+}
+
+// powerSavePollInterval returns the interval the ISR routine polls the chip at in power-save mode while no reply is
+// awaited.
+func (w *WINC) powerSavePollInterval() time.Duration {
+	if w.PowerSavePollInterval > 0 {
+		return w.PowerSavePollInterval
+	}
+
+	return time.Second
+}
+
+func (w *WINC) isr(signal <-chan bool, interval, powerSaveInterval time.Duration) {
 	// Send the shutdown signal when this routine eventually returns
 	defer func() { w.isrShutdownSignal <- true }()
 
+	// Without an interrupt line the chip is checked for packets on every tick
+	var poll <-chan time.Time
+	var lastPoll time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
 	// Loop forever until the driver is reset
 	for {
 		select {
@@ -192,24 +343,47 @@ func (w *WINC) isr(signal <-chan bool) {
 				return
 			}
 
-			// Wake the chip
-			err := w.hif.ChipWake()
-
-			if err == nil {
-				// Handle the interrupt
-				err = w.hif.Isr()
-				if err != nil {
-					debug.DEBUG("ISR error: %v", err)
-				}
-				// Sleep the chip
-				if err = w.hif.ChipSleep(); err != nil {
-					debug.DEBUG("ISR error: %v", err)
-				}
-			} else {
-				debug.DEBUG("ISR error: %v", err)
+			w.handleInterrupt(false)
+		case now := <-poll:
+			// Checking wakes the chip. Let it doze in power-save mode unless a reply is awaited.
+			if atomic.LoadUint32(&w.powerSaving) != 0 && !w.pending.waiting() && now.Sub(lastPoll) < powerSaveInterval {
+				continue
 			}
+
+			lastPoll = now
+			w.handleInterrupt(true)
+		}
+	}
+}
+
+// handleInterrupt receives the packet the chip signalled. When polling, the packet is only received if the chip raised
+// the interrupt.
+func (w *WINC) handleInterrupt(poll bool) {
+	// Wake the chip
+	err := w.hif.ChipWake()
+	if err != nil {
+		debug.DEBUG("ISR error: %v", err)
+		return
+	}
+
+	pending := true
+	if poll {
+		if pending, err = w.hif.InterruptPending(); err != nil {
+			debug.DEBUG("ISR error: %v", err)
 		}
 	}
+
+	if pending {
+		// Handle the interrupt
+		if err = w.hif.Isr(); err != nil {
+			debug.DEBUG("ISR error: %v", err)
+		}
+	}
+
+	// Sleep the chip
+	if err = w.hif.ChipSleep(); err != nil {
+		debug.DEBUG("ISR error: %v", err)
+	}
 }
 
 func (w *WINC) irqHandler(pin hal.Pin) {
@@ -0,0 +1,2179 @@
+package winc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/waj334/tinygo-winc/hal"
+	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/simulator"
+)
+
+func newTestDriver(t *testing.T) (*WINC, *simulator.Chip) {
+	chip := simulator.New()
+	drv := &WINC{
+		SPI:       chip,
+		CS:        chip.CS(),
+		IRQ:       chip.IRQ(),
+		EnablePin: chip.EnablePin(),
+		ResetPin:  chip.ResetPin(),
+	}
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	t.Cleanup(drv.Reset)
+	return drv, chip
+}
+
+func waitForEvent(t *testing.T, events <-chan protocol.Event, match func(e protocol.Event) bool) protocol.Event {
+	t.Helper()
+
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if match(e) {
+				return e
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	timeout := time.After(time.Second * 5)
+	for !condition() {
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Fatal("timed out waiting for condition")
+		}
+	}
+}
+
+func connectTestNetwork(t *testing.T, drv *WINC, chip *simulator.Chip) {
+	t.Helper()
+
+	chip.AddNetwork("winc", "password")
+	events := drv.OpenEventChannel()
+
+	if err := drv.WifiConnectPsk(WifiConnectionSettings{
+		Ssid:       "winc",
+		Passphrase: "password",
+	}); err != nil {
+		t.Fatalf("WifiConnectPsk: %v", err)
+	}
+
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*IpConfig)
+		return ok
+	})
+}
+
+func TestInitialize(t *testing.T) {
+	drv, _ := newTestDriver(t)
+
+	if id, err := drv.hif.GetChipId(); err != nil {
+		t.Fatal(err)
+	} else if id != simulator.DefaultChipID {
+		t.Errorf("Expected chip ID %#x, got %#x", simulator.DefaultChipID, id)
+	}
+}
+
+func TestTransferSizes(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	// Transfers of a single byte and of whole data packets are the edge cases of the SPI framing
+	for _, size := range []int{1, 3, 1024} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			conn, err := drv.Dial("tcp", "10.0.0.1:7")
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			expected := make([]byte, size)
+			for i := range expected {
+				expected[i] = byte(i)
+			}
+
+			done := make(chan error, 1)
+			echo := make(chan []byte, 1)
+			go func() {
+				if _, err := conn.Write(expected); err != nil {
+					done <- err
+					return
+				}
+
+				buf := make([]byte, size)
+				_, err := io.ReadFull(conn, buf)
+				echo <- buf
+				done <- err
+			}()
+
+			select {
+			case err = <-done:
+				if err != nil {
+					t.Fatalf("Echo: %v", err)
+				} else if !bytes.Equal(<-echo, expected) {
+					t.Errorf("The echo of %d bytes differs", size)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the echo")
+			}
+		})
+	}
+}
+
+func TestAccept(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	// Occupy the first socket so that the listener gets another one
+	first, err := drv.Dial("tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	listener, err := drv.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	// The firmware hands the freed first socket to the accepted connection
+	first.Close()
+
+	remote, err := chip.Dial(8080)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer remote.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+
+		if addr := conn.RemoteAddr().String(); addr != remote.LocalAddr().String() {
+			t.Errorf("Expected the remote address %v, got %v", remote.LocalAddr(), addr)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the connection on socket 0")
+	}
+}
+
+func TestWifiConnectPsk(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		psk        []byte
+		expected   WifiState
+	}{
+		{
+			name:       "valid-passphrase",
+			passphrase: "password",
+			expected:   WifiStateConnected,
+		},
+		{
+			name:       "invalid-passphrase",
+			passphrase: "wrong-password",
+			expected:   WifiStateDisconnected,
+		},
+		{
+			name:     "valid-psk",
+			psk:      DerivePSK("winc", "password"),
+			expected: WifiStateConnected,
+		},
+		{
+			name:     "invalid-psk",
+			psk:      DerivePSK("other", "password"),
+			expected: WifiStateDisconnected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			chip.AddNetwork("winc", "password")
+			events := drv.OpenEventChannel()
+
+			if err := drv.WifiConnectPsk(WifiConnectionSettings{
+				Ssid:       "winc",
+				Passphrase: tt.passphrase,
+				PSK:        tt.psk,
+			}); err != nil {
+				t.Fatalf("WifiConnectPsk: %v", err)
+			}
+
+			e := waitForEvent(t, events, func(e protocol.Event) bool {
+				_, ok := e.Data.(*WifiStateChanged)
+				return ok
+			})
+
+			if state := e.Data.(*WifiStateChanged).CurrentState; state != tt.expected {
+				t.Errorf("Expected state %v, got %v", tt.expected, state)
+			}
+
+			if state := drv.GetWifiState(); state != tt.expected {
+				t.Errorf("Expected driver state %v, got %v", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestConnectDefault(t *testing.T) {
+	tests := []struct {
+		name     string
+		storage  WifiCredOption
+		delete   func(drv *WINC) error
+		stored   []string
+		expected error
+	}{
+		{
+			name:     "stored",
+			storage:  WifiCredSaveEncrypted,
+			stored:   []string{"winc"},
+			expected: nil,
+		},
+		{
+			name:     "not-stored",
+			storage:  WifiCredDontSave,
+			expected: ErrNoStoredCredentials,
+		},
+		{
+			name:    "deleted",
+			storage: WifiCredSaveEncrypted,
+			delete: func(drv *WINC) error {
+				return drv.DeleteStoredCredentials("winc")
+			},
+			expected: ErrNoStoredCredentials,
+		},
+		{
+			name:    "deleted-all",
+			storage: WifiCredSaveUnencrypted,
+			delete: func(drv *WINC) error {
+				return drv.DeleteAllStoredCredentials()
+			},
+			expected: ErrNoStoredCredentials,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			chip.AddNetwork("winc", "password")
+			events := drv.OpenEventChannel()
+
+			if err := drv.WifiConnectPsk(WifiConnectionSettings{
+				Ssid:       "winc",
+				Passphrase: "password",
+				Storage:    tt.storage,
+			}); err != nil {
+				t.Fatalf("WifiConnectPsk: %v", err)
+			}
+
+			waitForEvent(t, events, func(e protocol.Event) bool {
+				_, ok := e.Data.(*IpConfig)
+				return ok
+			})
+
+			if tt.delete != nil {
+				if err := tt.delete(drv); err != nil {
+					t.Fatalf("Delete: %v", err)
+				}
+			}
+
+			if stored := drv.StoredNetworks(); !reflect.DeepEqual(stored, tt.stored) {
+				t.Errorf("Expected the stored networks %v, got %v", tt.stored, stored)
+			}
+
+			// The stored credentials must survive a reset
+			drv.Reset()
+			if err := drv.Initialize(); err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			if err := drv.ConnectDefault(ctx); err != tt.expected {
+				t.Fatalf("Expected %v, got %v", tt.expected, err)
+			}
+
+			if tt.expected == nil && drv.GetWifiState() != WifiStateConnected {
+				t.Error("Expected the driver to be connected")
+			}
+		})
+	}
+}
+
+func TestConnectDefaultSuccessReply(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+	events := drv.OpenEventChannel()
+
+	if err := drv.WifiConnectPsk(WifiConnectionSettings{
+		Ssid:       "winc",
+		Passphrase: "password",
+		Storage:    WifiCredSaveEncrypted,
+	}); err != nil {
+		t.Fatalf("WifiConnectPsk: %v", err)
+	}
+
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*IpConfig)
+		return ok
+	})
+
+	drv.Reset()
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	// Hold the state change so that the zero result code completes the request
+	chip.DefaultConnectSuccess = true
+	chip.HoldReplies(func(group, opcode byte) bool {
+		return group == byte(GroupWIFI) && opcode == byte(OpcodeWifiRespConStateChanged)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := drv.ConnectDefault(ctx); err != nil {
+		t.Fatalf("ConnectDefault: %v", err)
+	}
+
+	events = drv.OpenEventChannel()
+	chip.HoldReplies(nil)
+
+	// The address is assigned after the released state change
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*IpConfig)
+		return ok
+	})
+
+	if state := drv.GetWifiState(); state != WifiStateConnected {
+		t.Errorf("Expected %v, got %v", WifiStateConnected, state)
+	}
+
+	if n := drv.StaleReplies(); n != 0 {
+		t.Errorf("Expected no stale replies, got %d", n)
+	}
+}
+
+func TestStoredNetworks(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("home", "password")
+	chip.AddNetwork("guest", "password")
+	events := drv.OpenEventChannel()
+
+	connect := func(ssid, passphrase string, expected WifiState) {
+		t.Helper()
+
+		if err := drv.WifiConnectPsk(WifiConnectionSettings{
+			Ssid:       ssid,
+			Passphrase: passphrase,
+			Storage:    WifiCredSaveEncrypted,
+		}); err != nil {
+			t.Fatalf("WifiConnectPsk: %v", err)
+		}
+
+		waitForEvent(t, events, func(e protocol.Event) bool {
+			state, ok := e.Data.(*WifiStateChanged)
+			return ok && WifiState(state.CurrentState) == expected
+		})
+	}
+
+	connect("home", "password", WifiStateConnected)
+	connect("guest", "password", WifiStateConnected)
+	connect("home", "password", WifiStateConnected)
+
+	// The firmware doesn't store credentials that failed to connect
+	connect("guest", "wrong-password", WifiStateDisconnected)
+
+	expected := []string{"guest", "home"}
+	if stored := drv.StoredNetworks(); !reflect.DeepEqual(stored, expected) {
+		t.Errorf("Expected the stored networks %v, got %v", expected, stored)
+	}
+}
+
+func TestWifiConnectEnterprise(t *testing.T) {
+	// A DER encoded certificate of 1000 bytes and a 2048-bit key, as typically issued to clients
+	certificate := append([]byte{0x30, 0x82, 0x03, 0xe4}, bytes.Repeat([]byte{0xa5}, 996)...)
+	modulus := bytes.Repeat([]byte{0xff}, 256)
+
+	tests := []struct {
+		name        string
+		credentials WifiEnterpriseCredentials
+		expected    WifiState
+		err         error
+	}{
+		{
+			name: "mschapv2",
+			credentials: WifiEnterpriseCredentials{
+				Username: "user",
+				Domain:   "corp",
+				Password: "password",
+			},
+			expected: WifiStateConnected,
+		},
+		{
+			name: "mschapv2-invalid-password",
+			credentials: WifiEnterpriseCredentials{
+				Username: "user",
+				Password: "wrong-password",
+			},
+			expected: WifiStateDisconnected,
+		},
+		{
+			name: "tls",
+			credentials: WifiEnterpriseCredentials{
+				Method:              WifiEnterpriseTLS,
+				Username:            "user",
+				UnencryptedUsername: true,
+				Certificate:         certificate,
+				PrivateKeyModulus:   modulus,
+				PrivateKeyExponent:  []byte{0x01, 0x00, 0x01},
+			},
+			expected: WifiStateConnected,
+		},
+		{
+			name: "tls-certificate-too-large",
+			credentials: WifiEnterpriseCredentials{
+				Method:             WifiEnterpriseTLS,
+				Username:           "user",
+				Certificate:        append(certificate, make([]byte, 100)...),
+				PrivateKeyModulus:  modulus,
+				PrivateKeyExponent: []byte{0x01, 0x00, 0x01},
+			},
+			err: ErrInvalidParameter,
+		},
+		{
+			name: "tls-missing-certificate",
+			credentials: WifiEnterpriseCredentials{
+				Method:             WifiEnterpriseTLS,
+				Username:           "user",
+				PrivateKeyModulus:  bytes.Repeat([]byte{0xff}, 128),
+				PrivateKeyExponent: []byte{0x01, 0x00, 0x01},
+			},
+			err: ErrInvalidParameter,
+		},
+		{
+			name: "missing-username",
+			credentials: WifiEnterpriseCredentials{
+				Password: "password",
+			},
+			err: ErrInvalidParameter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			chip.AddAccessPoint(simulator.AccessPoint{SSID: "corp", Username: "user", Passphrase: "password"})
+			events := drv.OpenEventChannel()
+
+			err := drv.WifiConnectEnterprise(WifiConnectionSettings{Ssid: "corp"}, tt.credentials)
+			if err != tt.err {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			} else if err != nil {
+				return
+			}
+
+			e := waitForEvent(t, events, func(e protocol.Event) bool {
+				_, ok := e.Data.(*WifiStateChanged)
+				return ok
+			})
+
+			if state := e.Data.(*WifiStateChanged).CurrentState; state != tt.expected {
+				t.Errorf("Expected state %v, got %v", tt.expected, state)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	networks := []simulator.AccessPoint{
+		{
+			SSID:       "home",
+			Passphrase: "password",
+			BSSID:      [6]byte{0x02, 0, 0, 0, 0, 1},
+			RSSI:       -40,
+			Channel:    1,
+		},
+		{
+			SSID:    "guest",
+			BSSID:   [6]byte{0x02, 0, 0, 0, 0, 2},
+			RSSI:    -70,
+			Channel: 6,
+		},
+	}
+
+	home := ScanResult{
+		SSID:         "home",
+		BSSID:        [6]byte{0x02, 0, 0, 0, 0, 1},
+		RSSI:         -40,
+		Channel:      WifiChannel1,
+		SecurityType: WifiSecurityWpaPsk,
+	}
+
+	guest := ScanResult{
+		SSID:         "guest",
+		BSSID:        [6]byte{0x02, 0, 0, 0, 0, 2},
+		RSSI:         -70,
+		Channel:      WifiChannel6,
+		SecurityType: WifiSecurityOpen,
+	}
+
+	// The firmware numbers the results of each scan
+	indexed := func(results ...ScanResult) []ScanResult {
+		for i := range results {
+			results[i].Index = uint8(i)
+		}
+		return results
+	}
+
+	tests := []struct {
+		name     string
+		options  ScanOptions
+		expected []ScanResult
+		err      error
+	}{
+		{
+			name:     "active",
+			expected: indexed(home, guest),
+		},
+		{
+			name: "passive",
+			options: ScanOptions{
+				Passive:         true,
+				PassiveScanTime: time.Millisecond * 100,
+			},
+			expected: indexed(home, guest),
+		},
+		{
+			name: "channel",
+			options: ScanOptions{
+				Channel: WifiChannel6,
+			},
+			expected: indexed(guest),
+		},
+		{
+			name: "empty-channel",
+			options: ScanOptions{
+				Channel: WifiChannel11,
+			},
+			expected: []ScanResult{},
+		},
+		{
+			name: "invalid-channel",
+			options: ScanOptions{
+				Channel: 20,
+			},
+			err: ErrInvalidParameter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			for _, ap := range networks {
+				chip.AddAccessPoint(ap)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			results, err := drv.Scan(ctx, tt.options)
+			if err != tt.err {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+
+			if tt.err == nil && !reflect.DeepEqual(results, tt.expected) {
+				t.Errorf("\nExpected \t%+v\nGot \t\t%+v", tt.expected, results)
+			}
+		})
+	}
+}
+
+func TestScanCancelled(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := drv.Scan(ctx, ScanOptions{}); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	// A stale reply of the cancelled scan must not affect the next scan
+	results, err := drv.Scan(context.Background(), ScanOptions{})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	} else if len(results) != 1 || results[0].SSID != "winc" {
+		t.Errorf("Expected the test network, got %+v", results)
+	}
+}
+
+func TestScanLateResult(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("home", "password")
+	chip.AddNetwork("guest", "")
+
+	// Hold back the second result of the first scan until the second scan requests its first result
+	var results int
+	chip.HoldReplies(func(group, opcode byte) bool {
+		if group != byte(GroupWIFI) || opcode != OpcodeWifiRespScanResult {
+			return false
+		}
+
+		results++
+		return results == 2
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	if _, err := drv.Scan(ctx, ScanOptions{}); err != ErrOperationTimeout {
+		t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+	}
+
+	found, err := drv.Scan(context.Background(), ScanOptions{})
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if len(found) != 2 || found[0].Index != 0 || found[0].SSID != "home" || found[1].SSID != "guest" {
+		t.Errorf("Expected home and guest in order, got %+v", found)
+	}
+}
+
+func TestScanConcurrentCalls(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+
+	// The scan waits for a reply that never comes
+	chip.DropReplies(func(group, opcode byte) bool {
+		return group == byte(GroupWIFI) && opcode == OpcodeWifiRespScanDone
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	scanning := make(chan error, 1)
+	go func() {
+		_, err := drv.Scan(ctx, ScanOptions{})
+		scanning <- err
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+
+	// Other calls proceed while the scan waits
+	if err := drv.SetDeviceName("winc"); err != nil {
+		t.Fatalf("SetDeviceName: %v", err)
+	}
+
+	select {
+	case err := <-scanning:
+		t.Fatalf("Expected the scan to still wait, got %v", err)
+	default:
+	}
+
+	cancel()
+	if err := <-scanning; err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestConcurrentCalls(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	// The request waits for a reply that never comes
+	chip.DropReplies(func(group, opcode byte) bool {
+		return group == byte(GroupWIFI) && opcode == OpcodeWifiRespConnInfo
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := drv.GetConnectionInfoContext(ctx)
+		waiting <- err
+	}()
+
+	time.Sleep(time.Millisecond * 50)
+
+	// Calls that send commands and calls that wait for replies proceed in the meantime
+	done := make(chan error, 1)
+	go func() {
+		if err := drv.SetDeviceName("winc"); err != nil {
+			done <- err
+		} else if err = drv.DeleteStoredCredentials("winc"); err != nil {
+			done <- err
+		} else if state := drv.GetWifiState(); state != WifiStateConnected {
+			done <- fmt.Errorf("expected the state %v, got %v", WifiStateConnected, state)
+		} else {
+			_, err = drv.GetSystemTime(context.Background())
+			if err == ErrSystemTimeUnknown {
+				err = nil
+			}
+			done <- err
+		}
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the calls")
+	}
+
+	cancel()
+	if err := <-waiting; err != context.Canceled {
+		t.Errorf("Expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestStartWPS(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger WPSTrigger
+		pin     string
+		err     error
+	}{
+		{"push button", WPSTriggerPushButton, "", nil},
+		{"pin", WPSTriggerPIN, "12345670", nil},
+		{"wrong pin", WPSTriggerPIN, "00000000", ErrWPSFailed},
+		{"short pin", WPSTriggerPIN, "1234", ErrInvalidParameter},
+		{"invalid trigger", WPSTrigger(1), "", ErrInvalidParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			chip.AddAccessPoint(simulator.AccessPoint{SSID: "winc", Passphrase: "password", Channel: 6})
+			chip.EnableWPS("winc", "12345670")
+
+			creds, err := drv.StartWPS(context.Background(), tt.trigger, tt.pin)
+			if err != tt.err {
+				t.Fatalf("Expected %v, got %v", tt.err, err)
+			} else if err != nil {
+				return
+			}
+
+			expected := WPSCredentials{
+				SecurityType: WifiSecurityWpaPsk,
+				Channel:      6,
+				SSID:         "winc",
+				Passphrase:   "password",
+			}
+			if *creds != expected {
+				t.Errorf("Expected %+v, got %+v", expected, *creds)
+			}
+		})
+	}
+}
+
+func TestStartWPSCancelled(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := drv.StartWPS(ctx, WPSTriggerPushButton, ""); err != ErrOperationTimeout {
+		t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+	}
+
+	if chip.WPSActive() {
+		t.Error("Expected WPS to be disabled")
+	}
+}
+
+func TestConnectWPS(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+	chip.EnableWPS("winc", "")
+	events := drv.OpenEventChannel()
+
+	if _, err := drv.ConnectWPS(context.Background(), WPSTriggerPushButton, "", WifiCredDontSave); err != nil {
+		t.Fatalf("ConnectWPS: %v", err)
+	}
+
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*IpConfig)
+		return ok
+	})
+}
+
+func TestDial(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	chip.AddHost("example.com", net.IPv4(93, 184, 216, 34))
+	chip.Handle("93.184.216.34:80", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	conn, err := drv.Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	expected := []byte("hello winc")
+	if _, err = conn.Write(expected); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, len(expected))
+	if _, err = conn.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if !bytes.Equal(buf, expected) {
+		t.Errorf("\nExpected \t%q\nGot \t\t%q", expected, buf)
+	}
+}
+
+func TestMultipleInstances(t *testing.T) {
+	tests := []struct {
+		name       string
+		packetSize int
+	}{
+		{
+			name:       "uplink",
+			packetSize: 1024,
+		},
+		{
+			name:       "access-point",
+			packetSize: 256,
+		},
+	}
+
+	conns := make([]net.Conn, len(tests))
+	for i, tt := range tests {
+		chip := simulator.New()
+		drv := &WINC{
+			SPI:            chip,
+			CS:             chip.CS(),
+			IRQ:            chip.IRQ(),
+			EnablePin:      chip.EnablePin(),
+			ResetPin:       chip.ResetPin(),
+			DataPacketSize: tt.packetSize,
+		}
+
+		if err := drv.Initialize(); err != nil {
+			t.Fatalf("%s: Initialize: %v", tt.name, err)
+		}
+		t.Cleanup(drv.Reset)
+
+		connectTestNetwork(t, drv, chip)
+
+		chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		})
+
+		conn, err := drv.Dial("tcp", "10.0.0.1:7")
+		if err != nil {
+			t.Fatalf("%s: Dial: %v", tt.name, err)
+		}
+		defer conn.Close()
+		conns[i] = conn
+	}
+
+	// Both drivers exchange data at the same time
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func(conn net.Conn, name string) {
+			defer wg.Done()
+
+			expected := bytes.Repeat([]byte(name), 100)
+			if _, err := conn.Write(expected); err != nil {
+				t.Errorf("%s: Write: %v", name, err)
+				return
+			}
+
+			buf := make([]byte, len(expected))
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Errorf("%s: Read: %v", name, err)
+			} else if !bytes.Equal(buf, expected) {
+				t.Errorf("%s: Expected %q, got %q", name, expected, buf)
+			}
+		}(conns[i], tt.name)
+	}
+	wg.Wait()
+}
+
+func TestReplyTimeout(t *testing.T) {
+	const (
+		groupWifi         = byte(GroupWIFI)
+		groupIP           = byte(GroupIP)
+		opcodeConnInfo    = byte(OpcodeWifiRespConnInfo)
+		opcodeDnsResolve  = byte(OpcodeSocketDnsResolve)
+		opcodeBind        = byte(OpcodeSocketBind)
+		opcodeConnect     = byte(OpcodeSocketConnect)
+		opcodeListenReply = byte(OpcodeSocketListen)
+	)
+
+	tests := []struct {
+		name   string
+		group  byte
+		opcode byte
+		call   func(ctx context.Context, drv *WINC) error
+	}{
+		{
+			name:   "connection-info",
+			group:  groupWifi,
+			opcode: opcodeConnInfo,
+			call: func(ctx context.Context, drv *WINC) error {
+				_, err := drv.GetConnectionInfoContext(ctx)
+				return err
+			},
+		},
+		{
+			name:   "connection-info-reply-timeout",
+			group:  groupWifi,
+			opcode: opcodeConnInfo,
+			call: func(ctx context.Context, drv *WINC) error {
+				drv.ReplyTimeout = time.Millisecond * 100
+				defer func() { drv.ReplyTimeout = 0 }()
+
+				_, err := drv.GetConnectionInfo()
+				return err
+			},
+		},
+		{
+			name:   "dns",
+			group:  groupIP,
+			opcode: opcodeDnsResolve,
+			call: func(ctx context.Context, drv *WINC) error {
+				_, err := drv.GetHostByNameContext(ctx, "example.com")
+				return err
+			},
+		},
+		{
+			name:   "connect",
+			group:  groupIP,
+			opcode: opcodeConnect,
+			call: func(ctx context.Context, drv *WINC) error {
+				_, err := drv.DialContext(ctx, "tcp", "example.com:80", false)
+				return err
+			},
+		},
+		{
+			name:   "bind",
+			group:  groupIP,
+			opcode: opcodeBind,
+			call: func(ctx context.Context, drv *WINC) error {
+				_, err := drv.ListenContext(ctx, "tcp", ":8080")
+				return err
+			},
+		},
+		{
+			name:   "listen",
+			group:  groupIP,
+			opcode: opcodeListenReply,
+			call: func(ctx context.Context, drv *WINC) error {
+				_, err := drv.ListenContext(ctx, "tcp", ":8080")
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			connectTestNetwork(t, drv, chip)
+			chip.AddHost("example.com", net.IPv4(93, 184, 216, 34))
+			chip.Handle("93.184.216.34:80", func(conn net.Conn) {
+				conn.Close()
+			})
+
+			chip.DropReplies(func(group, opcode byte) bool {
+				return group == tt.group && opcode == tt.opcode
+			})
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+
+			if err := tt.call(ctx, drv); err != ErrOperationTimeout {
+				t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+			}
+
+			// The driver must remain usable
+			chip.DropReplies(nil)
+
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+
+			if err := tt.call(ctx, drv); err != nil {
+				t.Fatalf("Expected the driver to recover, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLateReply(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddHost("example.com", net.IPv4(93, 184, 216, 34))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := drv.GetConnectionInfoContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected %v, got %v", context.Canceled, err)
+	}
+
+	// The late connection info reply must not be mistaken for the DNS reply
+	address, err := drv.GetHostByName("example.com")
+	if err != nil {
+		t.Fatalf("GetHostByName: %v", err)
+	}
+
+	if ip := net.IP(binary.LittleEndian.AppendUint32(nil, address)); !ip.Equal(net.IPv4(93, 184, 216, 34)) {
+		t.Errorf("Expected %v, got %v", net.IPv4(93, 184, 216, 34), ip)
+	}
+
+	// The late reply is dropped and counted
+	if n := drv.StaleReplies(); n != 1 {
+		t.Errorf("Expected 1 stale reply, got %d", n)
+	}
+}
+
+func TestAcceptTimeout(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	listener, err := drv.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if _, err = listener.(*Socket).AcceptContext(ctx); err != ErrOperationTimeout {
+		t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	listener, err := drv.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	remote, err := chip.Dial(8080)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer remote.Close()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept: %v", err)
+	}
+
+	if addr := conn.LocalAddr().(*net.IPNet); !addr.IP.Equal(chip.IPConfig.IP) {
+		t.Errorf("Expected local address %v, got %v", chip.IPConfig.IP, addr.IP)
+	}
+
+	if _, err = remote.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err = conn.Read(buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	} else if string(buf) != "ping" {
+		t.Errorf("Expected %q, got %q", "ping", buf)
+	}
+
+	if _, err = conn.Write([]byte("pong")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	if _, err = io.ReadFull(remote, buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	} else if string(buf) != "pong" {
+		t.Errorf("Expected %q, got %q", "pong", buf)
+	}
+}
+
+func TestReset(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	if !chip.IRQ().Enabled() {
+		t.Fatal("Expected the interrupt to be enabled after initialization")
+	}
+
+	chip.EnablePin().ResetTransitions()
+	chip.ResetPin().ResetTransitions()
+
+	drv.Reset()
+
+	if chip.IRQ().Enabled() {
+		t.Error("Expected the interrupt to be disabled after reset")
+	}
+
+	expected := []bool{false, true}
+	for name, pin := range map[string]*hal.SoftwarePin{"enable": chip.EnablePin(), "reset": chip.ResetPin()} {
+		if transitions := pin.Transitions(); !reflect.DeepEqual(transitions, expected) {
+			t.Errorf("Expected %s pin transitions %v, got %v", name, expected, transitions)
+		}
+	}
+
+	// The driver must be able to come back up after the reset
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+}
+
+func TestResetWakesWaiters(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	connectTestNetwork(t, drv, chip)
+
+	// The remote never answers
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		io.Copy(io.Discard, conn)
+	})
+
+	conn, err := drv.Dial("tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	listener, err := drv.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	errs := make(chan error, 2)
+	go func() {
+		_, err := conn.Read(make([]byte, 4))
+		errs <- err
+	}()
+	go func() {
+		_, err := listener.Accept()
+		errs <- err
+	}()
+
+	// Let both calls start waiting
+	time.Sleep(time.Millisecond * 100)
+	drv.Reset()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errs:
+			if err != net.ErrClosed {
+				t.Errorf("Expected %v, got %v", net.ErrClosed, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the callers to wake up")
+		}
+	}
+
+	if err = drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+}
+
+func TestSpuriousInterrupt(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	// The ISR must tolerate interrupts without a pending packet
+	for i := 0; i < 10; i++ {
+		chip.IRQ().Trigger()
+	}
+
+	if _, err := drv.GetConnectionInfo(); err != nil {
+		t.Fatalf("GetConnectionInfo: %v", err)
+	}
+}
+
+func TestConnectionManager(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+
+	m := &ConnectionManager{
+		Driver: drv,
+		Networks: []WifiConnectionSettings{
+			{Ssid: "missing", Passphrase: "password"},
+			{Ssid: "winc", Passphrase: "password"},
+		},
+		MinBackoff:  time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		MaxAttempts: 2,
+	}
+	states := m.OpenStateChannel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	waitForState := func(expected ConnectionState) {
+		t.Helper()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case state := <-states:
+				if state == expected {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for state %v, the state is %v", expected, m.State())
+			}
+		}
+	}
+
+	// The manager falls back to the second network
+	waitForState(ConnectionStateHasIP)
+
+	info, err := drv.GetConnectionInfo()
+	if err != nil {
+		t.Fatalf("GetConnectionInfo: %v", err)
+	} else if info.SSID != "winc" {
+		t.Errorf("Expected to be connected to winc, got %q", info.SSID)
+	}
+
+	// The manager reconnects after the connection drops
+	chip.Disconnect()
+	waitForState(ConnectionStateBackingOff)
+	waitForState(ConnectionStateHasIP)
+
+	cancel()
+	if err = <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if state := m.State(); state != ConnectionStateIdle {
+		t.Errorf("Expected state %v, got %v", ConnectionStateIdle, state)
+	}
+}
+
+func TestConnectionManagerCoalescedEvents(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+	chip.AddHost("example.com", net.IPv4(93, 184, 216, 34))
+
+	m := &ConnectionManager{
+		Driver:     drv,
+		Networks:   []WifiConnectionSettings{{Ssid: "winc", Passphrase: "password"}},
+		MinBackoff: 100 * time.Millisecond,
+	}
+	states := m.OpenStateChannel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	waitForState := func(expected ConnectionState) {
+		t.Helper()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case state := <-states:
+				if state == expected {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for state %v, the state is %v", expected, m.State())
+			}
+		}
+	}
+
+	// The address is assigned right after the connection is established
+	waitForState(ConnectionStateHasIP)
+
+	// The connection drops right before a DNS reply arrives
+	chip.HoldReplies(func(group, opcode byte) bool {
+		return group == byte(GroupWIFI) && opcode == byte(OpcodeWifiRespConStateChanged)
+	})
+	chip.Disconnect()
+
+	if _, err := drv.GetHostByName("example.com"); err != nil {
+		t.Fatalf("GetHostByName: %v", err)
+	}
+	chip.HoldReplies(nil)
+
+	waitForState(ConnectionStateBackingOff)
+	waitForState(ConnectionStateHasIP)
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestConnectionManagerEnterprise(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddAccessPoint(simulator.AccessPoint{SSID: "corp", Username: "user", Passphrase: "password"})
+
+	m := &ConnectionManager{
+		Driver:   drv,
+		Networks: []WifiConnectionSettings{{Ssid: "corp", Security: WifiSecurity8021X}},
+		EnterpriseCredentials: map[string]WifiEnterpriseCredentials{
+			"corp": {Username: "user", Password: "password"},
+		},
+		MinBackoff: time.Millisecond,
+	}
+	states := m.OpenStateChannel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- m.Run(ctx)
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for state := ConnectionStateIdle; state != ConnectionStateHasIP; {
+		select {
+		case state = <-states:
+		case <-timeout:
+			t.Fatalf("Timed out waiting for state %v, the state is %v", ConnectionStateHasIP, m.State())
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestConnectionManagerReset(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+
+	m := &ConnectionManager{
+		Driver:   drv,
+		Networks: []WifiConnectionSettings{{Ssid: "winc", Passphrase: "password"}},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- m.Run(context.Background())
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	drv.Reset()
+
+	// The manager stops once the driver closed its state channel
+	select {
+	case err := <-done:
+		if err != net.ErrClosed {
+			t.Errorf("Expected %v, got %v", net.ErrClosed, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for Run to return")
+	}
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+}
+
+func TestWaitForIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcome  simulator.DHCPOutcome
+		expected error
+	}{
+		{
+			name:    "assigned",
+			outcome: simulator.DHCPSuccess,
+		},
+		{
+			name:     "dhcp-failure",
+			outcome:  simulator.DHCPFailure,
+			expected: ErrDHCPFailed,
+		},
+		{
+			name:     "ip-conflict",
+			outcome:  simulator.DHCPConflict,
+			expected: &IPConflictError{IP: net.IPv4(192, 168, 1, 10).To4()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drv, chip := newTestDriver(t)
+			chip.AddNetwork("winc", "password")
+			chip.DHCP = tt.outcome
+
+			if err := drv.WifiConnectPsk(WifiConnectionSettings{
+				Ssid:       "winc",
+				Passphrase: "password",
+			}); err != nil {
+				t.Fatalf("WifiConnectPsk: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			config, err := drv.WaitForIP(ctx)
+			if !reflect.DeepEqual(err, tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, err)
+			} else if err != nil {
+				return
+			}
+
+			if ip := binary.LittleEndian.Uint32(chip.IPConfig.IP.To4()); config.StaticIP != ip {
+				t.Errorf("Expected address %#x, got %#x", ip, config.StaticIP)
+			}
+
+			if config.DhcpLeaseTime != chip.IPConfig.LeaseTime {
+				t.Errorf("Expected lease time %v, got %v", chip.IPConfig.LeaseTime, config.DhcpLeaseTime)
+			}
+		})
+	}
+}
+
+func TestStaticIP(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+	events := drv.OpenEventChannel()
+
+	if err := drv.SetDHCPEnabled(false); err != nil {
+		t.Fatalf("SetDHCPEnabled: %v", err)
+	}
+
+	if err := drv.WifiConnectPsk(WifiConnectionSettings{
+		Ssid:       "winc",
+		Passphrase: "password",
+	}); err != nil {
+		t.Fatalf("WifiConnectPsk: %v", err)
+	}
+
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*WifiStateChanged)
+		return ok
+	})
+
+	// No address is assigned without DHCP
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := drv.WaitForIP(ctx); err != ErrOperationTimeout {
+		t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+	}
+
+	ip := net.IPv4(10, 0, 0, 42).To4()
+	config := IpConfig{
+		StaticIP:   IPv4ToUint32(ip),
+		Gateway:    IPv4ToUint32(net.IPv4(10, 0, 0, 1)),
+		DNS:        IPv4ToUint32(net.IPv4(10, 0, 0, 1)),
+		SubnetMask: IPv4ToUint32(net.IPv4(255, 255, 255, 0)),
+	}
+	if err := drv.SetStaticIP(config); err != nil {
+		t.Fatalf("SetStaticIP: %v", err)
+	}
+
+	assigned, err := drv.WaitForIP(context.Background())
+	if err != nil {
+		t.Fatalf("WaitForIP: %v", err)
+	} else if *assigned != config {
+		t.Errorf("Expected %+v, got %+v", config, *assigned)
+	}
+
+	info, err := drv.GetConnectionInfo()
+	if err != nil {
+		t.Fatalf("GetConnectionInfo: %v", err)
+	} else if !net.IP(info.IPAddress[:]).Equal(ip) {
+		t.Errorf("Expected the firmware to use %v, got %v", ip, net.IP(info.IPAddress[:]))
+	}
+
+	listener, err := drv.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+
+	if addr := listener.Addr().(*net.IPNet); !addr.IP.Equal(ip) || addr.Mask.String() != "ffffff00" {
+		t.Errorf("Expected listener address %v/24, got %v", ip, addr)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	// The channel is required
+	if _, err := drv.StartMonitor(MonitorFilter{}); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("Expected %v without a channel, got %v", ErrInvalidParameter, err)
+	}
+
+	frames, err := drv.StartMonitor(MonitorFilter{
+		Channel:      WifiChannel6,
+		FrameType:    WifiFrameTypeManagement,
+		Subtype:      WifiFrameSubtypeBeacon,
+		MatchSubtype: true,
+	})
+	if err != nil {
+		t.Fatalf("StartMonitor: %v", err)
+	}
+
+	bssid := [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	header := func(fc byte) []byte {
+		frame := make([]byte, 24)
+		frame[0] = fc
+		copy(frame[4:10], []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+		copy(frame[10:16], bssid[:])
+		copy(frame[16:22], bssid[:])
+		return frame
+	}
+
+	beacon := append(header(0x80), []byte("beacon body")...)
+	if chip.ReceiveFrame(header(0x08), -70) {
+		t.Error("Expected the data frame to be filtered")
+	}
+	if !chip.ReceiveFrame(beacon, -42) {
+		t.Fatal("Expected the beacon to be delivered")
+	}
+
+	var frame *MonitorFrame
+	select {
+	case frame = <-frames:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the beacon")
+	}
+
+	if frame.FrameType != WifiFrameTypeManagement || frame.Subtype != WifiFrameSubtypeBeacon {
+		t.Errorf("Expected a beacon, got type %v subtype %v", frame.FrameType, frame.Subtype)
+	}
+	if frame.BSSID != bssid || frame.Source != bssid || frame.RSSI != -42 || frame.Channel != WifiChannel6 {
+		t.Errorf("Unexpected frame metadata %+v", frame)
+	}
+	if !bytes.Equal(frame.Data, beacon) || frame.FrameLength != len(beacon) {
+		t.Errorf("Expected frame % x, got % x", beacon, frame.Data)
+	}
+
+	var buf bytes.Buffer
+	writer, err := NewPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewPcapWriter: %v", err)
+	}
+	if err = writer.WriteFrame(frame); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	pcap := buf.Bytes()
+	if len(pcap) != 24+16+15+len(beacon) {
+		t.Fatalf("Unexpected pcap length %v", len(pcap))
+	}
+	if linkType := binary.LittleEndian.Uint32(pcap[20:]); linkType != 127 {
+		t.Errorf("Expected the radiotap link type, got %v", linkType)
+	}
+	if frequency := binary.LittleEndian.Uint16(pcap[24+16+10:]); frequency != 2437 {
+		t.Errorf("Expected frequency 2437, got %v", frequency)
+	}
+	if !bytes.Equal(pcap[24+16+15:], beacon) {
+		t.Error("Expected the record to end with the frame")
+	}
+
+	if err = drv.InjectFrame(beacon, 24); err != nil {
+		t.Fatalf("InjectFrame: %v", err)
+	}
+	if injected := chip.InjectedFrames(); len(injected) != 1 || !bytes.Equal(injected[0], beacon) {
+		t.Errorf("Expected the beacon to be injected, got %x", injected)
+	}
+
+	if err = drv.StopMonitor(); err != nil {
+		t.Fatalf("StopMonitor: %v", err)
+	}
+	if _, ok := <-frames; ok {
+		t.Error("Expected the channel to be closed")
+	}
+	if chip.ReceiveFrame(beacon, -42) {
+		t.Error("Expected no frames to be delivered after monitor mode ended")
+	}
+}
+
+func TestGetSystemTime(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	if _, err := drv.GetSystemTime(context.Background()); err != ErrSystemTimeUnknown {
+		t.Fatalf("Expected %v, got %v", ErrSystemTimeUnknown, err)
+	}
+
+	chip.SystemTime = time.Date(2024, time.March, 5, 13, 37, 42, 0, time.UTC)
+
+	now, err := drv.GetSystemTime(context.Background())
+	if err != nil {
+		t.Fatalf("GetSystemTime: %v", err)
+	} else if !now.Equal(chip.SystemTime) {
+		t.Errorf("Expected %v, got %v", chip.SystemTime, now)
+	}
+
+	if err = drv.ConfigureSNTP(SNTPConfig{Servers: []string{"*.pool.ntp.org"}, UseDHCP: true}); err != nil {
+		t.Fatalf("ConfigureSNTP: %v", err)
+	}
+
+	if err = drv.SetSNTPEnabled(false); err != nil {
+		t.Fatalf("SetSNTPEnabled: %v", err)
+	}
+
+	if server, useDHCP, enabled := chip.SNTP(); server != "*.pool.ntp.org" || !useDHCP || enabled {
+		t.Errorf("Unexpected SNTP configuration server=%q useDHCP=%v enabled=%v", server, useDHCP, enabled)
+	}
+
+	for _, config := range []SNTPConfig{
+		{},
+		{Servers: []string{""}},
+		{Servers: []string{"a.example.com", "b.example.com"}},
+		{Servers: []string{"a.example.com"}, Interval: -time.Second},
+	} {
+		if err = drv.ConfigureSNTP(config); err != ErrInvalidParameter {
+			t.Errorf("%+v: expected %v, got %v", config, ErrInvalidParameter, err)
+		}
+	}
+}
+
+func TestSNTPResync(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	config := SNTPConfig{Servers: []string{"a.example.com", "b.example.com"}, Interval: 10 * time.Millisecond}
+	if err := drv.ConfigureSNTP(config); err != nil {
+		t.Fatalf("ConfigureSNTP: %v", err)
+	}
+
+	// The servers take turns at every resync
+	for _, want := range []string{"a.example.com", "b.example.com", "a.example.com"} {
+		deadline := time.Now().Add(time.Second)
+		for server, _, _ := chip.SNTP(); server != want; server, _, _ = chip.SNTP() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected server %q, got %q", want, server)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// A new configuration replaces the routine
+	if err := drv.ConfigureSNTP(SNTPConfig{Servers: []string{"c.example.com"}}); err != nil {
+		t.Fatalf("ConfigureSNTP: %v", err)
+	}
+
+	time.Sleep(5 * config.Interval)
+	if server, _, enabled := chip.SNTP(); server != "c.example.com" || !enabled {
+		t.Errorf("Unexpected SNTP configuration server=%q enabled=%v", server, enabled)
+	}
+}
+
+func TestMACAddress(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	for name, get := range map[string]func() (net.HardwareAddr, error){
+		"MACAddress":    drv.MACAddress,
+		"OTPMACAddress": drv.OTPMACAddress,
+	} {
+		if mac, err := get(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		} else if !bytes.Equal(mac, chip.MACAddress) {
+			t.Errorf("%s: expected %v, got %v", name, chip.MACAddress, mac)
+		}
+	}
+
+	override := net.HardwareAddr{0x02, 0x00, 0x00, 0x12, 0x34, 0x56}
+	if err := drv.SetMACAddress(override); err != nil {
+		t.Fatalf("SetMACAddress: %v", err)
+	}
+
+	if mac, err := drv.MACAddress(); err != nil {
+		t.Fatalf("MACAddress: %v", err)
+	} else if !bytes.Equal(mac, override) {
+		t.Errorf("Expected %v, got %v", override, mac)
+	}
+
+	if mac, err := drv.OTPMACAddress(); err != nil {
+		t.Fatalf("OTPMACAddress: %v", err)
+	} else if !bytes.Equal(mac, chip.MACAddress) {
+		t.Errorf("Expected %v, got %v", chip.MACAddress, mac)
+	}
+
+	if err := drv.SetMACAddress(override[:4]); err != ErrInvalidParameter {
+		t.Errorf("Expected %v, got %v", ErrInvalidParameter, err)
+	}
+
+	// A chip without a programmed address
+	chip.MACAddress = nil
+	drv.Reset()
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	if _, err := drv.OTPMACAddress(); err != ErrNoMACAddress {
+		t.Errorf("Expected %v, got %v", ErrNoMACAddress, err)
+	}
+}
+
+func TestSetDeviceName(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	for _, name := range []string{
+		"",
+		"-sensor",
+		"sensor-",
+		"sensor_01",
+		"sensor.local",
+		"sënsor",
+		strings.Repeat("a", 48),
+	} {
+		if err := drv.SetDeviceName(name); err != ErrInvalidParameter {
+			t.Errorf("%q: expected %v, got %v", name, ErrInvalidParameter, err)
+		}
+	}
+
+	if err := drv.SetDeviceName("Sensor-01"); err != nil {
+		t.Fatalf("SetDeviceName: %v", err)
+	}
+
+	if name := chip.DeviceName(); name != "Sensor-01" {
+		t.Errorf("Expected %q, got %q", "Sensor-01", name)
+	}
+}
+
+func TestConnectProvisioning(t *testing.T) {
+	drv, chip := newTestDriver(t)
+	chip.AddNetwork("winc", "password")
+	events := drv.OpenEventChannel()
+
+	config := ProvisioningConfig{
+		AP: APModeConfig{
+			APConfig: APConfig{
+				SSID:         "winc-setup",
+				Channel:      WifiChannel1,
+				SecurityType: WifiSecurityOpen,
+				DHCP:         [4]byte{192, 168, 1, 1},
+			},
+		},
+		Domain:   "setup.winc",
+		Redirect: true,
+	}
+
+	go func() {
+		for {
+			if domain, active := chip.Provisioning(); active {
+				if domain != config.Domain {
+					t.Errorf("Expected domain %q, got %q", config.Domain, domain)
+				}
+
+				chip.Provision("winc", "password")
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	info, err := drv.ConnectProvisioning(context.Background(), config, WifiCredDontSave)
+	if err != nil {
+		t.Fatalf("ConnectProvisioning: %v", err)
+	} else if info.SSID != "winc" || info.Passphrase != "password" || info.SecurityType != WifiSecurityWpaPsk {
+		t.Errorf("Unexpected provisioning info %+v", info)
+	}
+
+	waitForEvent(t, events, func(e protocol.Event) bool {
+		_, ok := e.Data.(*IpConfig)
+		return ok
+	})
+
+	// Giving up tears the access point down
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err = drv.StartProvisioning(ctx, config); err != ErrOperationTimeout {
+		t.Fatalf("Expected %v, got %v", ErrOperationTimeout, err)
+	}
+
+	if _, active := chip.Provisioning(); active {
+		t.Error("Expected provisioning mode to be stopped")
+	}
+}
+
+func TestPowerSave(t *testing.T) {
+	drv, chip := newTestDriver(t)
+
+	if err := drv.RequestSleep(time.Second); err != ErrNotManualPowerSave {
+		t.Errorf("Expected %v, got %v", ErrNotManualPowerSave, err)
+	}
+
+	if err := drv.SetPowerSaveMode(PowerSaveManual, false); err != nil {
+		t.Fatalf("SetPowerSaveMode: %v", err)
+	}
+
+	if err := drv.SetListenInterval(3); err != nil {
+		t.Fatalf("SetListenInterval: %v", err)
+	}
+
+	if mode, broadcast, interval := chip.PowerSave(); mode != int(PowerSaveManual) || broadcast || interval != 3 {
+		t.Errorf("Unexpected power-save configuration mode=%v broadcast=%v interval=%v", mode, broadcast, interval)
+	}
+
+	if err := drv.RequestSleep(0); err != ErrInvalidParameter {
+		t.Errorf("Expected %v, got %v", ErrInvalidParameter, err)
+	}
+
+	if err := drv.RequestSleep(1500 * time.Millisecond); err != nil {
+		t.Fatalf("RequestSleep: %v", err)
+	}
+
+	if d := chip.LastDoze(); d != 1500*time.Millisecond {
+		t.Errorf("Expected doze of %v, got %v", 1500*time.Millisecond, d)
+	}
+
+	// The driver must wake the chip up before every access while it may doze
+	connectTestNetwork(t, drv, chip)
+
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	conn, err := drv.Dial("tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write(buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+
+	if _, err = drv.MACAddress(); err != nil {
+		t.Fatalf("MACAddress: %v", err)
+	}
+
+	if n := chip.AsleepAccesses(); n != 0 {
+		t.Errorf("The host accessed the chip %d times while it may doze", n)
+	}
+}
+
+func TestFirmwareInfo(t *testing.T) {
+	tests := []struct {
+		name     string
+		chipID   uint32
+		firmware simulator.Firmware
+		expected FirmwareInfo
+		err      bool
+	}{
+		{
+			name:     "default",
+			firmware: simulator.DefaultFirmware,
+			expected: FirmwareInfo{
+				ChipID:                simulator.DefaultChipID,
+				Family:                protocol.ChipFamilyWINC1500,
+				FirmwareVersion:       Version{19, 7, 7},
+				RequiredDriverVersion: Version{19, 3, 0},
+				HifVersion:            HifVersion{Block: 2, Major: 1, Minor: 3},
+				BuildDate:             "Jan 20 2022",
+				BuildTime:             "10:31:09",
+			},
+		},
+		{
+			name: "legacy",
+			firmware: simulator.Firmware{
+				Version:          [3]byte{19, 5, 4},
+				MinDriverVersion: [3]byte{19, 3, 0},
+				Legacy:           true,
+			},
+			expected: FirmwareInfo{
+				ChipID:                simulator.DefaultChipID,
+				Family:                protocol.ChipFamilyWINC1500,
+				FirmwareVersion:       Version{19, 5, 4},
+				RequiredDriverVersion: Version{19, 3, 0},
+			},
+		},
+		{
+			name:     "winc3400",
+			chipID:   simulator.WINC3400ChipID,
+			firmware: simulator.WINC3400Firmware,
+			expected: FirmwareInfo{
+				ChipID:                simulator.WINC3400ChipID,
+				Family:                protocol.ChipFamilyWINC3400,
+				FirmwareVersion:       Version{1, 4, 6},
+				RequiredDriverVersion: Version{1, 3, 0},
+				HifVersion:            HifVersion{Block: 2, Major: 1, Minor: 3},
+				BuildDate:             "Mar 14 2022",
+				BuildTime:             "16:02:45",
+			},
+		},
+		{
+			name: "legacy-newer-driver-required",
+			firmware: simulator.Firmware{
+				Version:          [3]byte{19, 5, 4},
+				MinDriverVersion: [3]byte{19, 8, 0},
+				Legacy:           true,
+			},
+			err: true,
+		},
+		{
+			name: "incompatible-hif",
+			firmware: simulator.Firmware{
+				Version:          [3]byte{20, 0, 0},
+				MinDriverVersion: [3]byte{19, 3, 0},
+				HifInfo:          2<<14 | 2<<8,
+			},
+			err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chip := simulator.New()
+			chip.Firmware = tt.firmware
+			if tt.chipID != 0 {
+				chip.ChipID = tt.chipID
+			}
+
+			drv := &WINC{
+				SPI:       chip,
+				CS:        chip.CS(),
+				IRQ:       chip.IRQ(),
+				EnablePin: chip.EnablePin(),
+				ResetPin:  chip.ResetPin(),
+			}
+			t.Cleanup(drv.Reset)
+
+			// Boot the firmware image
+			drv.Reset()
+
+			err := drv.Initialize()
+			if tt.err {
+				if _, ok := err.(*FirmwareVersionError); !ok {
+					t.Fatalf("Expected a firmware version error, got %v", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("Initialize: %v", err)
+			}
+
+			info, err := drv.FirmwareInfo()
+			if err != nil {
+				t.Fatalf("FirmwareInfo: %v", err)
+			} else if *info != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, *info)
+			}
+		})
+	}
+}
+
+func TestHifVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		register uint32 // Revision register. The host interface info of the firmware is held in the lower half.
+		expected HifVersion
+	}{
+		{
+			// 19.7.7 firmware with the same image in the OTA partition, M2M_HIF_BLOCK_VALUE 2, M2M_HIF_MAJOR_VALUE 1
+			// and M2M_HIF_MINOR_VALUE 3
+			name:     "19.7.7",
+			register: 0x81038103,
+			expected: HifVersion{Block: 2, Major: 1, Minor: 3},
+		},
+		{
+			name:     "no-ota-image",
+			register: 0x00008102,
+			expected: HifVersion{Block: 2, Major: 1, Minor: 2},
+		},
+		{
+			name:     "masks",
+			register: 0x0000FFFF,
+			expected: HifVersion{Block: 3, Major: 63, Minor: 255},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if version := hifVersion(uint16(tt.register)); version != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, version)
+			}
+		})
+	}
+}
+func newWINC3400TestDriver(t *testing.T) (*WINC, *simulator.Chip) {
+	chip := simulator.New()
+	chip.ChipID = simulator.WINC3400ChipID
+	chip.Firmware = simulator.WINC3400Firmware
+
+	drv := &WINC{
+		SPI:       chip,
+		CS:        chip.CS(),
+		IRQ:       chip.IRQ(),
+		EnablePin: chip.EnablePin(),
+		ResetPin:  chip.ResetPin(),
+	}
+	t.Cleanup(drv.Reset)
+
+	// Boot the firmware image
+	drv.Reset()
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	return drv, chip
+}
+
+func TestWINC3400(t *testing.T) {
+	drv, chip := newWINC3400TestDriver(t)
+
+	if family := drv.hif.Family(); family != protocol.ChipFamilyWINC3400 {
+		t.Fatalf("Expected %v, got %v", protocol.ChipFamilyWINC3400, family)
+	}
+
+	// The driver announces the release whose host interface it speaks
+	expectedVersion := Version{1, 4, 6}
+	announced := chip.HostVersion() >> 16
+	if version := (Version{uint8(announced >> 8), uint8(announced>>4) & 0xF, uint8(announced) & 0xF}); version != expectedVersion {
+		t.Errorf("Expected the driver version %v, got %v", expectedVersion, version)
+	} else if version = releaseOf(protocol.ChipFamilyWINC3400).version; version != expectedVersion {
+		t.Errorf("Expected the release %v, got %v", expectedVersion, version)
+	}
+
+	// The wake-up handshake uses the registers of the WINC3400
+	if err := drv.SetPowerSaveMode(PowerSaveDeepAutomatic, true); err != nil {
+		t.Fatalf("SetPowerSaveMode: %v", err)
+	}
+
+	connectTestNetwork(t, drv, chip)
+
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	conn, err := drv.Dial("tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	expected := []byte("hello winc3400")
+	if _, err = conn.Write(expected); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, len(expected))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	} else if !bytes.Equal(buf, expected) {
+		t.Errorf("Expected %q, got %q", expected, buf)
+	}
+
+	if n := chip.AsleepAccesses(); n != 0 {
+		t.Errorf("The host accessed the chip %d times while it may doze", n)
+	}
+}
+
+func TestPollingMode(t *testing.T) {
+	chip := simulator.New()
+	drv := &WINC{
+		SPI:          chip,
+		CS:           chip.CS(),
+		EnablePin:    chip.EnablePin(),
+		ResetPin:     chip.ResetPin(),
+		PollInterval: time.Millisecond,
+
+		PowerSavePollInterval: 50 * time.Millisecond,
+	}
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	t.Cleanup(drv.Reset)
+
+	if chip.IRQ().Enabled() {
+		t.Fatal("Expected the interrupt to stay disabled without an IRQ pin")
+	}
+
+	// Polling must wake the chip before checking for packets
+	if err := drv.SetPowerSaveMode(PowerSaveDeepAutomatic, true); err != nil {
+		t.Fatalf("SetPowerSaveMode: %v", err)
+	}
+
+	connectTestNetwork(t, drv, chip)
+
+	chip.Handle("10.0.0.1:7", func(conn net.Conn) {
+		defer conn.Close()
+		io.Copy(conn, conn)
+	})
+
+	conn, err := drv.Dial("tcp", "10.0.0.1:7")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// Idle polls are spaced out so that the chip can doze
+	wakeUps := chip.WakeUps()
+	time.Sleep(200 * time.Millisecond)
+	if n := chip.WakeUps() - wakeUps; n > 10 {
+		t.Errorf("Expected the chip to be left dozing, woke it up %d times", n)
+	}
+
+	expected := []byte("hello polling")
+	if _, err = conn.Write(expected); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, len(expected))
+	if _, err = io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read: %v", err)
+	} else if !bytes.Equal(buf, expected) {
+		t.Errorf("Expected %q, got %q", expected, buf)
+	}
+
+	if n := chip.AsleepAccesses(); n != 0 {
+		t.Errorf("The host accessed the chip %d times while it may doze", n)
+	}
+
+	// The polling routine stops with the reset
+	drv.Reset()
+	if err = drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+}
@@ -26,6 +26,8 @@ package winc
 
 import (
 	"errors"
+	"fmt"
+	"net"
 )
 
 var (
@@ -34,6 +36,13 @@ var (
 	ErrNoAvailableSocket  = errors.New("no available socket")
 	ErrSocketDoesNotExist = errors.New("socket does not exist")
 	ErrUnknown            = errors.New("unknown error occurred")
+	ErrScanFailed         = errors.New("scan failed")
+	ErrWPSFailed          = errors.New("WPS failed")
+	ErrProvisioningFailed = errors.New("provisioning failed")
+	ErrDHCPFailed         = errors.New("DHCP failed")
+	ErrSystemTimeUnknown  = errors.New("system time is not synchronized")
+	ErrNoMACAddress       = errors.New("no MAC address is programmed")
+	ErrNotManualPowerSave = errors.New("power-save mode is not manual")
 
 	ErrSocketInvalidAddress     = SocketError(-1)
 	ErrSocketAddrAlreadyInUse   = SocketError(-2)
@@ -47,6 +56,11 @@ var (
 	ErrSocketTimeout            = SocketError(-13)
 	ErrSocketBufferFull         = SocketError(-14)
 	ErrSocketFuncNotImplemented = SocketError(-99)
+
+	ErrDefaultConnectInProgress = DefaultConnectError(-23)
+	ErrDefaultConnectFailed     = DefaultConnectError(-24)
+	ErrNoStoredNetworkFound     = DefaultConnectError(-25)
+	ErrNoStoredCredentials      = DefaultConnectError(-26)
 )
 
 type SocketError int8
@@ -79,3 +93,49 @@ func (s SocketError) Error() (err string) {
 
 	return
 }
+
+type DefaultConnectError int8
+
+func (d DefaultConnectError) Error() (err string) {
+	switch d {
+	case -23:
+		err = "a connection is already in progress"
+	case -24:
+		err = "failed to connect to the stored networks"
+	case -25:
+		err = "none of the stored networks were found"
+	case -26:
+		err = "no network credentials are stored"
+	default:
+		err = "default connect failed"
+	}
+
+	return
+}
+
+// IPConflictError is returned when another host on the network uses the address assigned to the station.
+type IPConflictError struct {
+	IP net.IP
+}
+
+func (i *IPConflictError) read(data []byte) {
+	i.IP = net.IPv4(data[0], data[1], data[2], data[3]).To4()
+}
+
+func (i *IPConflictError) Error() string {
+	return fmt.Sprintf("IP address %v is already in use", i.IP)
+}
+
+// FirmwareVersionError is returned by Initialize if the firmware speaks a host interface version the driver does not
+// support.
+type FirmwareVersionError struct {
+	Firmware    Version
+	FirmwareHif HifVersion
+	Driver      Version
+	DriverHif   HifVersion
+}
+
+func (f *FirmwareVersionError) Error() string {
+	return fmt.Sprintf("firmware %v (HIF %v) is incompatible with driver %v (HIF %v)",
+		f.Firmware, f.FirmwareHif, f.Driver, f.DriverHif)
+}
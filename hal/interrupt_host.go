@@ -0,0 +1,82 @@
+//go:build !tinygo
+
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hal
+
+// SoftwareInterruptPin is an InterruptPin that is triggered programmatically.
+type SoftwareInterruptPin struct {
+	SoftwarePin
+
+	handler func(Pin)
+	pending bool
+}
+
+func (i *SoftwareInterruptPin) Enable(fn func(Pin)) error {
+	i.mutex.Lock()
+	i.handler = fn
+	pending := i.pending && fn != nil
+	if pending {
+		i.pending = false
+	}
+	i.mutex.Unlock()
+
+	// Deliver the interrupt that was latched while the pin was disabled
+	if pending {
+		fn(i)
+	}
+
+	return nil
+}
+
+func (i *SoftwareInterruptPin) Disable() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.handler = nil
+	return nil
+}
+
+// Enabled reports whether an interrupt handler is currently installed.
+func (i *SoftwareInterruptPin) Enabled() bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.handler != nil
+}
+
+// Trigger signals the interrupt. The handler is called immediately if the interrupt is enabled. Otherwise, the
+// interrupt is latched and delivered once the pin is enabled.
+func (i *SoftwareInterruptPin) Trigger() {
+	i.mutex.Lock()
+	fn := i.handler
+	if fn == nil {
+		i.pending = true
+	}
+	i.mutex.Unlock()
+
+	if fn != nil {
+		fn(i)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !tinygo
+
+package hal
+
+import "testing"
+
+func TestSoftwareInterruptPin_Trigger(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		triggers int
+		expected int
+	}{
+		{
+			name:     "enabled",
+			enabled:  true,
+			triggers: 2,
+			expected: 2,
+		},
+		{
+			name:     "latched-while-disabled",
+			enabled:  false,
+			triggers: 2,
+			expected: 1, // Multiple triggers are latched as a single interrupt
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pin := &SoftwareInterruptPin{}
+			count := 0
+			handler := func(Pin) { count++ }
+
+			if tt.enabled {
+				pin.Enable(handler)
+			}
+
+			for i := 0; i < tt.triggers; i++ {
+				pin.Trigger()
+			}
+
+			if !tt.enabled {
+				pin.Enable(handler)
+			}
+
+			if count != tt.expected {
+				t.Errorf("Expected %v interrupts, got %v", tt.expected, count)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+//go:build !tinygo
+
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package hal
+
+import "sync"
+
+// SoftwarePin is a Pin for hosts without GPIO hardware. Every level driven on the pin is recorded so that tests can
+// verify the sequence the driver produced.
+type SoftwarePin struct {
+	mutex       sync.Mutex
+	level       bool
+	transitions []bool
+	onChange    func(level bool)
+}
+
+func (p *SoftwarePin) High() {
+	p.set(true)
+}
+
+func (p *SoftwarePin) Low() {
+	p.set(false)
+}
+
+// Get returns the current level of the pin.
+func (p *SoftwarePin) Get() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.level
+}
+
+// Transitions returns the levels driven on the pin in order. High is true and low is false.
+func (p *SoftwarePin) Transitions() []bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]bool(nil), p.transitions...)
+}
+
+// ResetTransitions clears the recorded levels.
+func (p *SoftwarePin) ResetTransitions() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.transitions = nil
+}
+
+// OnChange sets the function called whenever the level of the pin changes. This connects the pin to whatever is
+// driven by it.
+func (p *SoftwarePin) OnChange(fn func(level bool)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.onChange = fn
+}
+
+func (p *SoftwarePin) set(level bool) {
+	p.mutex.Lock()
+	changed := p.level != level
+	p.level = level
+	p.transitions = append(p.transitions, level)
+	fn := p.onChange
+	p.mutex.Unlock()
+
+	if changed && fn != nil {
+		fn(level)
+	}
+}
@@ -1,11 +1,21 @@
 package winc
 
 import (
+	"context"
 	"net"
 	"net/url"
 )
 
-func (w *WINC) Listen(network, address string) (listener net.Listener, err error) {
+func (w *WINC) Listen(network, address string) (net.Listener, error) {
+	ctx, cancel := w.replyContext()
+	defer cancel()
+
+	return w.ListenContext(ctx, network, address)
+}
+
+// ListenContext creates a socket listening on the local address. The context bounds how long the firmware may take to
+// set up the socket.
+func (w *WINC) ListenContext(ctx context.Context, network, address string) (listener net.Listener, err error) {
 	var socket *Socket
 	var addr net.Addr
 	var uri *url.URL
@@ -45,12 +55,18 @@ func (w *WINC) Listen(network, address string) (listener net.Listener, err error
 	}
 
 	// Bind the socket to the listen address
-	if err = socket.Bind(addr); err != nil {
+	if err = socket.BindContext(ctx, addr); err != nil {
+		// Free the socket
+		socket.Shutdown()
+
 		return nil, err
 	}
 
 	// Begin listening to the socket
-	if err = socket.Listen(1); err != nil {
+	if err = socket.ListenContext(ctx, 1); err != nil {
+		// Free the socket
+		socket.Shutdown()
+
 		return nil, err
 	}
 
@@ -0,0 +1,293 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+type ConnectionState uint8
+
+const (
+	ConnectionStateIdle ConnectionState = iota
+	ConnectionStateConnecting
+	ConnectionStateConnected
+	ConnectionStateHasIP
+	ConnectionStateBackingOff
+)
+
+func (c ConnectionState) String() string {
+	switch c {
+	case ConnectionStateIdle:
+		return "idle"
+	case ConnectionStateConnecting:
+		return "connecting"
+	case ConnectionStateConnected:
+		return "connected"
+	case ConnectionStateHasIP:
+		return "has-ip"
+	case ConnectionStateBackingOff:
+		return "backing-off"
+	}
+
+	return "unknown"
+}
+
+const (
+	defaultMinBackoff     = time.Second
+	defaultMaxBackoff     = time.Minute
+	defaultMaxAttempts    = 3
+	defaultConnectTimeout = 15 * time.Second
+)
+
+// ConnectionManager keeps the station connected to one of its networks. It reconnects with exponential backoff after
+// the connection drops and falls back to the next network once MaxAttempts consecutive attempts failed.
+type ConnectionManager struct {
+	Driver *WINC
+	// Networks in order of priority
+	Networks []WifiConnectionSettings
+
+	// Credentials of the networks with WifiSecurity8021X by SSID
+	EnterpriseCredentials map[string]WifiEnterpriseCredentials
+
+	// MinBackoff is the delay before the first reconnect attempt. It doubles after each failed attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of consecutive failed attempts after which the next network is used
+	MaxAttempts int
+	// ConnectTimeout is how long an attempt may take before it is considered failed
+	ConnectTimeout time.Duration
+
+	state    ConnectionState
+	channels []chan ConnectionState
+	mutex    sync.Mutex
+}
+
+// State returns the current state of the connection.
+func (m *ConnectionManager) State() ConnectionState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.state
+}
+
+// OpenStateChannel returns a channel that receives the state of the connection whenever it changes. Older states are
+// dropped if the channel is not read in time.
+func (m *ConnectionManager) OpenStateChannel() <-chan ConnectionState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	c := make(chan ConnectionState, 1)
+	m.channels = append(m.channels, c)
+	return c
+}
+
+func (m *ConnectionManager) setState(state ConnectionState) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.state == state {
+		return
+	}
+
+	m.state = state
+	for _, c := range m.channels {
+		select {
+		case <-c:
+			// Drop the state that was not read yet
+		default:
+		}
+		c <- state
+	}
+}
+
+// Run manages the connection until the context is done. The station is disconnected before Run returns. net.ErrClosed
+// is returned if the driver is reset in the meantime.
+func (m *ConnectionManager) Run(ctx context.Context) error {
+	if m.Driver == nil || len(m.Networks) == 0 {
+		return ErrInvalidParameter
+	}
+
+	minBackoff, maxBackoff := m.MinBackoff, m.MaxBackoff
+	if minBackoff <= 0 {
+		minBackoff = defaultMinBackoff
+	}
+
+	if maxBackoff < minBackoff {
+		maxBackoff = defaultMaxBackoff
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+	}
+
+	maxAttempts := m.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	connectTimeout := m.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	// Events may be dropped when they arrive in quick succession, so the state is tracked through a state channel
+	changes := m.Driver.openStateChannel()
+	defer m.Driver.closeStateChannel(changes)
+	defer m.setState(ConnectionStateIdle)
+
+	network, failures := 0, 0
+	backoff := minBackoff
+
+	for {
+		m.setState(ConnectionStateConnecting)
+
+		// Forget about the changes of the previous attempt
+		if !drainStateChanges(changes) {
+			return net.ErrClosed
+		}
+
+		connected := false
+		if err := m.connect(m.Networks[network]); err == nil {
+			var err error
+			if connected, err = m.waitConnected(ctx, changes, connectTimeout); err != nil {
+				return err
+			}
+		}
+
+		if ctx.Err() != nil {
+			m.Driver.WifiDisconnect()
+			return nil
+		}
+
+		if connected {
+			// Stay connected until the connection drops
+			failures = 0
+			backoff = minBackoff
+
+			if dropped, err := m.waitDisconnected(ctx, changes); err != nil {
+				return err
+			} else if !dropped {
+				m.Driver.WifiDisconnect()
+				return nil
+			}
+		} else {
+			if failures++; failures >= maxAttempts {
+				// Fall back to the next network right away
+				network = (network + 1) % len(m.Networks)
+				failures = 0
+				backoff = minBackoff
+				continue
+			}
+
+			// Abort the attempt in case the firmware is still trying
+			m.Driver.WifiDisconnect()
+		}
+
+		m.setState(ConnectionStateBackingOff)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// connect starts an attempt to connect to the network.
+func (m *ConnectionManager) connect(settings WifiConnectionSettings) error {
+	if settings.Security == WifiSecurity8021X {
+		return m.Driver.WifiConnectEnterprise(settings, m.EnterpriseCredentials[settings.Ssid])
+	}
+
+	return m.Driver.WifiConnectPsk(settings)
+}
+
+// drainStateChanges drops the signal of a state channel that was not read yet. It returns false if the channel was
+// closed.
+func drainStateChanges(changes <-chan struct{}) bool {
+	select {
+	case _, ok := <-changes:
+		return ok
+	default:
+		return true
+	}
+}
+
+// waitConnected waits for the attempt to connect to succeed. It returns false if the attempt failed, timed out or if
+// the context is done.
+func (m *ConnectionManager) waitConnected(ctx context.Context, changes <-chan struct{}, timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case _, ok := <-changes:
+		if !ok {
+			return false, net.ErrClosed
+		}
+	case <-timer.C:
+		return false, nil
+	case <-ctx.Done():
+		return false, nil
+	}
+
+	if m.Driver.GetWifiState() != WifiStateConnected {
+		return false, nil
+	}
+
+	m.setState(ConnectionStateConnected)
+	return true, nil
+}
+
+// waitDisconnected tracks the state of an established connection. It returns true once the connection drops and false
+// if the context is done.
+func (m *ConnectionManager) waitDisconnected(ctx context.Context, changes <-chan struct{}) (bool, error) {
+	for {
+		// The address may have been assigned along with the connection
+		if m.Driver.ipAssigned() {
+			m.setState(ConnectionStateHasIP)
+		}
+
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return false, net.ErrClosed
+			}
+
+			if m.Driver.GetWifiState() == WifiStateDisconnected {
+				return true, nil
+			}
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+}
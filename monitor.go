@@ -0,0 +1,270 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/waj334/tinygo-winc/debug"
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+type WifiFrameType uint8
+
+const (
+	WifiFrameTypeAny WifiFrameType = iota
+	WifiFrameTypeManagement
+	WifiFrameTypeControl
+	WifiFrameTypeData
+)
+
+// Subtypes of management frames
+const (
+	WifiFrameSubtypeAssocRequest     = 0x0
+	WifiFrameSubtypeAssocResponse    = 0x1
+	WifiFrameSubtypeReassocRequest   = 0x2
+	WifiFrameSubtypeReassocResponse  = 0x3
+	WifiFrameSubtypeProbeRequest     = 0x4
+	WifiFrameSubtypeProbeResponse    = 0x5
+	WifiFrameSubtypeBeacon           = 0x8
+	WifiFrameSubtypeATIM             = 0x9
+	WifiFrameSubtypeDisassociation   = 0xA
+	WifiFrameSubtypeAuthentication   = 0xB
+	WifiFrameSubtypeDeauthentication = 0xC
+	WifiFrameSubtypeAction           = 0xD
+)
+
+const defaultMonitorBufferLength = 16
+
+// MonitorFilter selects the frames captured in monitor mode. Zero values match any frame, except for Channel.
+type MonitorFilter struct {
+	// Channel to listen on. It is required since the firmware listens on one channel at a time.
+	Channel   WifiChannel
+	FrameType WifiFrameType
+	// Subtype is the 4-bit subtype of the frames to capture. It is only used if MatchSubtype is set.
+	Subtype      uint8
+	MatchSubtype bool
+
+	Source      [6]byte
+	Destination [6]byte
+	BSSID       [6]byte
+
+	// HeaderOnly captures the MAC header of the frames without their body
+	HeaderOnly bool
+}
+
+// MonitorFrame is an 802.11 frame captured in monitor mode.
+type MonitorFrame struct {
+	Timestamp    time.Time
+	Channel      WifiChannel
+	FrameType    WifiFrameType
+	Subtype      uint8
+	ServiceClass uint8
+	Priority     uint8
+	HeaderLength uint8
+	CipherType   uint8
+	Source       [6]byte
+	Destination  [6]byte
+	BSSID        [6]byte
+	// FrameLength is the length of the frame on air. Data holds less if the frame was truncated.
+	FrameLength int
+	DataRate    uint32 // kbps
+	RSSI        int8
+	// Data is the frame starting with its MAC header
+	Data []byte
+}
+
+// StartMonitor puts the station into monitor mode and returns the channel receiving the captured frames. Frames are
+// dropped while the channel is full. See MonitorDropped. The channel is closed by StopMonitor. ErrInvalidParameter is
+// returned if the filter has no channel.
+func (w *WINC) StartMonitor(filter MonitorFilter) (<-chan *MonitorFrame, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if filter.Channel < WifiChannel1 || filter.Channel > WifiChannel14 || filter.Subtype > 0xF ||
+		filter.FrameType > WifiFrameTypeData {
+		return nil, ErrInvalidParameter
+	}
+
+	w.monitorMutex.Lock()
+	defer w.monitorMutex.Unlock()
+
+	if w.monitorChan != nil {
+		return nil, ErrInvalidParameter
+	}
+
+	req := monitorModeCtrl{
+		channel:     byte(filter.Channel),
+		frameType:   filter.FrameType.wire(),
+		subtype:     0xFF,
+		source:      filter.Source,
+		destination: filter.Destination,
+		bssid:       filter.BSSID,
+		headerOnly:  filter.HeaderOnly,
+	}
+
+	if filter.MatchSubtype {
+		req.subtype = filter.Subtype << 4
+	}
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqEnableMonitoring, req.bytes(), nil, 0); err != nil {
+		return nil, err
+	}
+
+	length := w.MonitorBufferLength
+	if length <= 0 {
+		length = defaultMonitorBufferLength
+	}
+
+	w.monitorChan = make(chan *MonitorFrame, length)
+	w.monitorChannel = filter.Channel
+	return w.monitorChan, nil
+}
+
+// StopMonitor leaves monitor mode and closes the channel returned by StartMonitor.
+func (w *WINC) StopMonitor() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqDisableMonitoring, nil, nil, 0); err != nil {
+		return err
+	}
+
+	w.monitorMutex.Lock()
+	defer w.monitorMutex.Unlock()
+
+	if w.monitorChan != nil {
+		close(w.monitorChan)
+		w.monitorChan = nil
+	}
+
+	return nil
+}
+
+// MonitorDropped returns the number of captured frames that were dropped because the channel was full.
+func (w *WINC) MonitorDropped() uint32 {
+	return atomic.LoadUint32(&w.monitorDropped)
+}
+
+// InjectFrame transmits a raw 802.11 frame. The frame starts with its MAC header of headerLength bytes and excludes
+// the FCS.
+func (w *WINC) InjectFrame(frame []byte, headerLength int) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(frame) == 0 || len(frame) > 0xFFFF || headerLength <= 0 || headerLength > len(frame) {
+		return ErrInvalidParameter
+	}
+
+	req := txPacketInfo{
+		packetSize:   uint16(len(frame)),
+		headerLength: uint16(headerLength),
+	}
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqSendWifiPacket|protocol.OpcodeReqDataPkt, req.bytes(), frame, 4)
+}
+
+// receiveMonitorFrame reads a captured frame and passes it to the monitor channel.
+func (w *WINC) receiveMonitorFrame(size uint16, address uint32) error {
+	data := make([]byte, 36)
+	if err := w.hif.Receive(address, data, false); err != nil {
+		return err
+	}
+
+	info := rxPacketInfo{}
+	info.read(data)
+
+	frame := &MonitorFrame{
+		Timestamp:    time.Now(),
+		FrameType:    wifiFrameType(info.frameType),
+		Subtype:      info.subtype >> 4,
+		ServiceClass: info.serviceClass,
+		Priority:     info.priority,
+		HeaderLength: info.headerLength,
+		CipherType:   info.cipherType,
+		Source:       info.source,
+		Destination:  info.destination,
+		BSSID:        info.bssid,
+		FrameLength:  int(info.frameLength),
+		DataRate:     info.dataRate,
+		RSSI:         info.rssi,
+	}
+
+	length := int(info.dataLength)
+	if max := int(size) - len(data); length > max {
+		length = max
+	}
+
+	if length > 0 {
+		frame.Data = make([]byte, length)
+		if err := w.hif.Receive(address+uint32(len(data)), frame.Data, false); err != nil {
+			return err
+		}
+	}
+
+	w.monitorMutex.Lock()
+	defer w.monitorMutex.Unlock()
+
+	if w.monitorChan == nil {
+		return nil
+	}
+
+	frame.Channel = w.monitorChannel
+	select {
+	case w.monitorChan <- frame:
+	default:
+		debug.DEBUG("WIFI: Dropping captured frame")
+		atomic.AddUint32(&w.monitorDropped, 1)
+	}
+
+	return nil
+}
+
+func (f WifiFrameType) wire() byte {
+	switch f {
+	case WifiFrameTypeManagement:
+		return 0x00
+	case WifiFrameTypeControl:
+		return 0x04
+	case WifiFrameTypeData:
+		return 0x08
+	}
+
+	return 0xFF
+}
+
+func wifiFrameType(b byte) WifiFrameType {
+	switch b {
+	case 0x00:
+		return WifiFrameTypeManagement
+	case 0x04:
+		return WifiFrameTypeControl
+	case 0x08:
+		return WifiFrameTypeData
+	}
+
+	return WifiFrameTypeAny
+}
@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+const (
+	pcapMagic            = 0xA1B2C3D4
+	pcapSnapLength       = 65535
+	pcapLinkTypeRadiotap = 127
+
+	// The radiotap header carries the rate, the channel and the signal strength
+	radiotapPresent  = 1<<2 | 1<<3 | 1<<5
+	radiotapLength   = 15
+	radiotapChan2GHz = 0x0080
+)
+
+// PcapWriter writes captured frames in the pcap format with radiotap headers so that they can be opened in tools like
+// Wireshark.
+type PcapWriter struct {
+	w io.Writer
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a writer for the frames.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(header[4:], 2) // Version 2.4
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], pcapSnapLength)
+	binary.LittleEndian.PutUint32(header[20:], pcapLinkTypeRadiotap)
+
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &PcapWriter{w: w}, nil
+}
+
+// WriteFrame writes a captured frame as a pcap record.
+func (p *PcapWriter) WriteFrame(frame *MonitorFrame) error {
+	data := frame.Data
+	if len(data) > pcapSnapLength-radiotapLength {
+		data = data[:pcapSnapLength-radiotapLength]
+	}
+
+	frameLength := frame.FrameLength
+	if frameLength < len(frame.Data) {
+		frameLength = len(frame.Data)
+	}
+
+	record := make([]byte, 16+radiotapLength, 16+radiotapLength+len(data))
+
+	// Record header
+	binary.LittleEndian.PutUint32(record[0:], uint32(frame.Timestamp.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(frame.Timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(radiotapLength+len(data)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(radiotapLength+frameLength))
+
+	// Radiotap header
+	radiotap := record[16:]
+	binary.LittleEndian.PutUint16(radiotap[2:], radiotapLength)
+	binary.LittleEndian.PutUint32(radiotap[4:], radiotapPresent)
+
+	rate := frame.DataRate / 500
+	if rate > 0xFF {
+		rate = 0xFF
+	}
+	radiotap[8] = byte(rate)
+	// radiotap[9] pads the channel field to 2 bytes
+	binary.LittleEndian.PutUint16(radiotap[10:], channelFrequency(frame.Channel))
+	binary.LittleEndian.PutUint16(radiotap[12:], radiotapChan2GHz)
+	radiotap[14] = byte(frame.RSSI)
+
+	record = append(record, data...)
+	_, err := p.w.Write(record)
+	return err
+}
+
+// channelFrequency returns the center frequency of a 2.4 GHz channel in MHz.
+func channelFrequency(channel WifiChannel) uint16 {
+	if channel == WifiChannel14 {
+		return 2484
+	}
+
+	return 2407 + 5*uint16(channel)
+}
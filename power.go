@@ -0,0 +1,137 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// PowerSaveMode selects when the firmware lets the chip doze.
+type PowerSaveMode uint8
+
+const (
+	// PowerSaveNone keeps the chip awake at all times
+	PowerSaveNone PowerSaveMode = 0
+	// PowerSaveAutomatic lets the firmware doze between beacons while staying reachable by the host
+	PowerSaveAutomatic PowerSaveMode = 1
+	// PowerSaveDeepAutomatic lets the firmware doze between beacons in a deeper sleep state that saves more power
+	PowerSaveDeepAutomatic PowerSaveMode = 3
+	// PowerSaveManual lets the chip doze only when the host calls RequestSleep
+	PowerSaveManual PowerSaveMode = 4
+)
+
+func (p PowerSaveMode) String() string {
+	switch p {
+	case PowerSaveNone:
+		return "None"
+	case PowerSaveAutomatic:
+		return "Automatic"
+	case PowerSaveDeepAutomatic:
+		return "DeepAutomatic"
+	case PowerSaveManual:
+		return "Manual"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetPowerSaveMode selects the power-save mode of the firmware. The chip wakes up at every DTIM beacon to receive
+// broadcast traffic when receiveBroadcast is set. Otherwise, it wakes up at the listen interval. The driver wakes the
+// chip up before talking to it in every mode.
+func (w *WINC) SetPowerSaveMode(mode PowerSaveMode, receiveBroadcast bool) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	switch mode {
+	case PowerSaveNone, PowerSaveAutomatic, PowerSaveDeepAutomatic, PowerSaveManual:
+	default:
+		return ErrInvalidParameter
+	}
+
+	req := make([]byte, 4)
+	req[0] = byte(mode)
+	if receiveBroadcast {
+		req[1] = 1
+	}
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqSleep, req, nil, 0); err != nil {
+		return err
+	}
+
+	w.powerSaveMode = mode
+	if mode == PowerSaveNone {
+		atomic.StoreUint32(&w.powerSaving, 0)
+	} else {
+		atomic.StoreUint32(&w.powerSaving, 1)
+	}
+
+	return nil
+}
+
+// PowerSaveMode returns the power-save mode selected with SetPowerSaveMode.
+func (w *WINC) PowerSaveMode() PowerSaveMode {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.powerSaveMode
+}
+
+// SetListenInterval sets the number of beacon intervals the chip may doze before it wakes up to receive buffered
+// traffic. It only applies while broadcast traffic is not received in power-save mode.
+func (w *WINC) SetListenInterval(interval uint16) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if interval == 0 {
+		return ErrInvalidParameter
+	}
+
+	req := make([]byte, 4)
+	binary.LittleEndian.PutUint16(req, interval)
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqLsnInt, req, nil, 0)
+}
+
+// RequestSleep lets the chip doze for the duration d. This requires PowerSaveManual. The chip wakes up early when the
+// host talks to it.
+func (w *WINC) RequestSleep(d time.Duration) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.powerSaveMode != PowerSaveManual {
+		return ErrNotManualPowerSave
+	}
+
+	ms := d / time.Millisecond
+	if ms <= 0 || ms > 0xFFFFFFFF {
+		return ErrInvalidParameter
+	}
+
+	req := make([]byte, 4)
+	binary.LittleEndian.PutUint32(req, uint32(ms))
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqDoze, req, nil, 0)
+}
@@ -122,6 +122,11 @@ const (
 	_WAKE_CLOCK_REG = 0x1
 	_CLOCKS_EN_REG  = 0xF
 
+	// The WINC3400 moved the wake-up handshake registers
+	_CORT_HOST_COMM_3400 = 0x14
+	_HOST_CORT_COMM_3400 = 0x0E
+	_CLOCKS_EN_REG_3400  = 0x13
+
 	_WIFI_NORMAL_MODE     = 1
 	_WIFI_HOST_RCV_CTRL_0 = 0x1070
 	_WIFI_HOST_RCV_CTRL_1 = 0x1084
@@ -137,6 +142,10 @@ const (
 	_REV_B0           = 0x2B0
 	_REV_3A0          = 0x3A0
 
+	_FIRMWARE_VERSION_3400 = 0x0146 // 1.4.6
+	_DRIVER_VERSION_3400   = 0x0146 // 1.4.6, see DriverVersion
+	_VERSION_3400          = _FIRMWARE_VERSION_3400 | (_DRIVER_VERSION_3400 << 16)
+
 	// The maximum transmission unit for sending data blocks over the SPI bus
 	_SPI_BUS_MTU = 2048 - 8
 )
@@ -29,12 +29,7 @@ import (
 )
 
 // WINC default data packet size is 1024
-var dataPacketSize = 1024
-
-// SetDataPacketSize sets the data packet size returned from the device client. Call this before initialization.
-func SetDataPacketSize(sz int) {
-	dataPacketSize = sz
-}
+const defaultDataPacketSize = 1024
 
 type dataPacket []byte
 
@@ -42,7 +37,7 @@ func (data dataPacket) read(t *transport, clockless, crcEnabled bool) (err error
 	t.chipSelect(false)
 	defer t.chipSelect(true)
 	// Determine how many data packets will be read
-	count := (len(data) / dataPacketSize) + 1
+	count := (len(data) + t.packetSize - 1) / t.packetSize
 
 	offset := 0
 	for i := 0; i < count; i++ {
@@ -64,7 +59,7 @@ func (data dataPacket) read(t *transport, clockless, crcEnabled bool) (err error
 
 	readData:
 		// Receive the data
-		if _, err = t.Read(data[offset:min(len(data), offset+dataPacketSize)]); err != nil {
+		if _, err = t.Read(data[offset:min(len(data), offset+t.packetSize)]); err != nil {
 			return
 		}
 
@@ -76,7 +71,7 @@ func (data dataPacket) read(t *transport, clockless, crcEnabled bool) (err error
 		}
 
 		// Advance the offset
-		offset += dataPacketSize
+		offset += t.packetSize
 	}
 
 	return nil
@@ -90,7 +85,7 @@ func (data dataPacket) write(t *transport, crcEnabled bool) error {
 	defer debug.DEBUG("PROTOCOL: Done writing data")
 
 	// Determine how many data packets will be sent
-	count := (len(data) / dataPacketSize) + 1
+	count := (len(data) + t.packetSize - 1) / t.packetSize
 
 	debug.DEBUG("PROTOCOL: Will write %v data packets", count)
 
@@ -114,12 +109,12 @@ func (data dataPacket) write(t *transport, crcEnabled bool) error {
 		}
 
 		buf := chunk
-		if len(chunk) > dataPacketSize {
+		if len(chunk) > t.packetSize {
 			// Limit to the maximum data packet size
-			buf = buf[:dataPacketSize]
+			buf = buf[:t.packetSize]
 
 			// Advance to the next chunk
-			chunk = chunk[dataPacketSize:]
+			chunk = chunk[t.packetSize:]
 		}
 
 		// Transmit a slice of the data
@@ -0,0 +1,90 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package protocol
+
+// ChipFamily is the product family of a chip.
+type ChipFamily uint8
+
+const (
+	ChipFamilyUnknown ChipFamily = iota
+	ChipFamilyWINC1500
+	ChipFamilyWINC3400
+)
+
+// GetChipFamily returns the product family of the chip ID returned by Hif.GetChipId.
+func GetChipFamily(chipId uint32) ChipFamily {
+	switch chipId & 0xFFFFF000 {
+	case 0x150000:
+		return ChipFamilyWINC1500
+	case 0x300000:
+		return ChipFamilyWINC3400
+	}
+
+	return ChipFamilyUnknown
+}
+
+func (c ChipFamily) String() string {
+	switch c {
+	case ChipFamilyWINC1500:
+		return "WINC1500"
+	case ChipFamilyWINC3400:
+		return "WINC3400"
+	default:
+		return "Unknown"
+	}
+}
+
+// familyRegisters holds the registers whose address differs between the chip families. Apart from these, the driver
+// boots both families and exchanges HIF packets with them the same way.
+type familyRegisters struct {
+	cortHostComm uint32
+	hostCortComm uint32
+	clocksEn     uint32
+	version      uint32
+}
+
+func registersOf(family ChipFamily) familyRegisters {
+	if family == ChipFamilyWINC3400 {
+		return familyRegisters{
+			cortHostComm: _CORT_HOST_COMM_3400,
+			hostCortComm: _HOST_CORT_COMM_3400,
+			clocksEn:     _CLOCKS_EN_REG_3400,
+			version:      _VERSION_3400,
+		}
+	}
+
+	return familyRegisters{
+		cortHostComm: _CORT_HOST_COMM,
+		hostCortComm: _HOST_CORT_COMM,
+		clocksEn:     _CLOCKS_EN_REG,
+		version:      _VERSION,
+	}
+}
+
+// DriverVersion returns the version of the host driver announced to the firmware of the family at boot.
+func DriverVersion(family ChipFamily) (major, minor, patch uint8) {
+	version := registersOf(family).version >> 16
+	return uint8(version >> 8), uint8(version>>4) & 0xF, uint8(version) & 0xF
+}
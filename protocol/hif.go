@@ -25,8 +25,9 @@ SOFTWARE.
 package protocol
 
 import (
-	"runtime/volatile"
+	"encoding/binary"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"tinygo.org/x/drivers"
@@ -52,16 +53,15 @@ const (
 	OpcodeReqDataPkt   OpcodeId = 0x80 /*BIT7*/
 )
 
-var (
-	rxSize    uint32
-	rxAddress uint32
-	rxDone    uint8
-	chipId    uint32
-	callbacks [GroupMax]IsrCallback
-)
-
 type IsrCallback func(id OpcodeId, sz uint16, address uint32) (any, error)
 
+// Tracer observes the HIF level transactions performed by a Hif
+type Tracer interface {
+	TraceSend(group GroupId, opcode OpcodeId, control, data []byte, offset uint16)
+	TraceIsr(group GroupId, opcode OpcodeId, length uint16, address uint32)
+	TraceReceive(address uint32, data []byte, done bool)
+}
+
 type Event struct {
 	Group  GroupId
 	Opcode OpcodeId
@@ -71,20 +71,41 @@ type Event struct {
 type Hif struct {
 	t             transport
 	eventChannels []chan Event
-	mutex         sync.Mutex
+	tracer        Tracer
+	callbacks     [GroupMax]IsrCallback
+
+	rxSize    uint32
+	rxAddress uint32
+	rxDone    uint32
+	chipId    uint32
+	family    ChipFamily
+	regs      familyRegisters
+
+	// Number of users that need the chip awake. The chip may doze in power-save mode once this drops to zero.
+	wakeCount int
+
+	mutex      sync.Mutex
+	eventMutex sync.Mutex // Guards eventChannels, since events are emitted without holding mutex
 }
 
 func CreateHif(spi drivers.SPI, cs hal.Pin) Hif {
 	return Hif{
 		t: transport{
-			spi: spi,
-			cs:  cs,
+			spi:        spi,
+			cs:         cs,
+			packetSize: defaultDataPacketSize,
 		},
+		regs: registersOf(ChipFamilyUnknown),
 	}
 }
 
+// SetDataPacketSize sets the data packet size returned from the device client. Call this before initialization.
+func (hif *Hif) SetDataPacketSize(sz int) {
+	hif.t.packetSize = sz
+}
+
 func (hif *Hif) Init() (err error) {
-	callbacks = [GroupMax]IsrCallback{}
+	hif.callbacks = [GroupMax]IsrCallback{}
 
 	if err = hif.t.init(); err != nil {
 		return err
@@ -92,9 +113,10 @@ func (hif *Hif) Init() (err error) {
 
 	if chipId, err := hif.GetChipId(); err != nil {
 		return err
-	} else if (chipId >> 16) != 0x15 { // TODO: Allow WINC3400
+	} else if hif.family = GetChipFamily(chipId); hif.family == ChipFamilyUnknown {
 		return errIncompatibleVersion
 	}
+	hif.regs = registersOf(hif.family)
 
 	if err = hif.waitForBootrom(); err != nil {
 		return errBootromFailed
@@ -121,7 +143,7 @@ func (hif *Hif) InitDownload() (err error) {
 		return
 	}
 
-	if (chipId & 0xffff0000) != 0x300000 {
+	if GetChipFamily(chipId) != ChipFamilyWINC3400 {
 		if err = hif.Halt(); err != nil {
 			return
 		}
@@ -142,6 +164,7 @@ func (hif *Hif) InitDownload() (err error) {
 
 func (hif *Hif) Shutdown() {
 	// Close all event channels
+	hif.eventMutex.Lock()
 	for _, e := range hif.eventChannels {
 		select {
 		case <-e: // Drain the channel
@@ -150,29 +173,56 @@ func (hif *Hif) Shutdown() {
 			close(e)
 		}
 	}
+	hif.eventChannels = nil
+	hif.eventMutex.Unlock()
 
-	rxSize = 0
-	rxAddress = 0
-	rxDone = 0
-	chipId = 0
+	atomic.StoreUint32(&hif.rxSize, 0)
+	atomic.StoreUint32(&hif.rxAddress, 0)
+	atomic.StoreUint32(&hif.rxDone, 0)
+	hif.chipId = 0
 }
 
-func (hif *Hif) RegisterCallback(group GroupId, callback IsrCallback) {
+// SetTracer sets the tracer that observes all HIF transactions. Pass nil to stop tracing.
+func (hif *Hif) SetTracer(tracer Tracer) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
-	callbacks[group] = callback
+	hif.tracer = tracer
 }
 
-func (hif *Hif) OpenEventChannel() <-chan Event {
+func (hif *Hif) RegisterCallback(group GroupId, callback IsrCallback) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
+	hif.callbacks[group] = callback
+}
+
+func (hif *Hif) OpenEventChannel() <-chan Event {
+	hif.eventMutex.Lock()
+	defer hif.eventMutex.Unlock()
+
 	c := make(chan Event, 1)
 	hif.eventChannels = append(hif.eventChannels, c)
 	return c
 }
 
+// CloseEventChannel stops emitting events to the channel returned by OpenEventChannel and closes it. Channels that were
+// already closed by Shutdown are ignored.
+func (hif *Hif) CloseEventChannel(c <-chan Event) {
+	hif.eventMutex.Lock()
+	defer hif.eventMutex.Unlock()
+
+	for i, e := range hif.eventChannels {
+		if e == c {
+			hif.eventChannels = append(hif.eventChannels[:i], hif.eventChannels[i+1:]...)
+			close(e)
+			return
+		}
+	}
+}
+
+// ChipWake wakes the chip up. Every call must be paired with a call to ChipSleep. The chip stays awake until all users
+// put it back to sleep.
 func (hif *Hif) ChipWake() (err error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
@@ -180,12 +230,18 @@ func (hif *Hif) ChipWake() (err error) {
 }
 
 func (hif *Hif) chipWakeInternal() (err error) {
-	if volatile.LoadUint8(&rxDone) != 0 {
-		// Chip already wake
+	if hif.wakeCount++; hif.wakeCount > 1 {
+		// Chip already awake
 		return nil
 	}
 
-	if err = hif.t.WriteRegister(_HOST_CORT_COMM, _NBIT0); err != nil {
+	defer func() {
+		if err != nil {
+			hif.wakeCount--
+		}
+	}()
+
+	if err = hif.t.WriteRegister(hif.regs.hostCortComm, _NBIT0); err != nil {
 		return err
 	}
 	if err = hif.t.WriteRegister(_WAKE_CLOCK_REG, _NBIT1); err != nil {
@@ -196,7 +252,7 @@ func (hif *Hif) chipWakeInternal() (err error) {
 	// Receive clock enabled register until bit 2 is 1
 	for retries := 0; retries < 10; retries++ {
 		var reg uint32
-		if reg, err = hif.t.ReadRegister(_CLOCKS_EN_REG); err != nil {
+		if reg, err = hif.t.ReadRegister(hif.regs.clocksEn); err != nil {
 			return err
 		} else if reg&_NBIT2 != 0 {
 			// Reset the bus
@@ -212,6 +268,7 @@ func (hif *Hif) chipWakeInternal() (err error) {
 	return errChipWakeFail
 }
 
+// ChipSleep allows the chip to doze again once the last user that woke it up is done.
 func (hif *Hif) ChipSleep() error {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
@@ -219,8 +276,15 @@ func (hif *Hif) ChipSleep() error {
 }
 
 func (hif *Hif) chipSleepInternal() error {
+	if hif.wakeCount == 0 {
+		return nil
+	} else if hif.wakeCount--; hif.wakeCount > 0 {
+		// Another user still needs the chip
+		return nil
+	}
+
 	for {
-		result, err := hif.t.ReadRegister(_CORT_HOST_COMM)
+		result, err := hif.t.ReadRegister(hif.regs.cortHostComm)
 		if err != nil {
 			return err
 		} else if result&_NBIT0 == 0 {
@@ -241,14 +305,14 @@ func (hif *Hif) chipSleepInternal() error {
 		}
 	}
 
-	result, err = hif.t.ReadRegister(_HOST_CORT_COMM)
+	result, err = hif.t.ReadRegister(hif.regs.hostCortComm)
 	if err != nil {
 		return err
 	}
 
 	if result&_NBIT0 != 0 {
 		result &= ^uint32(_NBIT0)
-		err = hif.t.WriteRegister(_HOST_CORT_COMM, result)
+		err = hif.t.WriteRegister(hif.regs.hostCortComm, result)
 		if err != nil {
 			return err
 		}
@@ -257,14 +321,18 @@ func (hif *Hif) chipSleepInternal() error {
 	return nil
 }
 
+// Family returns the product family of the chip. It is known once the HIF is initialized.
+func (hif *Hif) Family() ChipFamily {
+	return hif.family
+}
+
 func (hif *Hif) GetChipId() (uint32, error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
-	if chipId == 0 {
-		var err error
+	if hif.chipId == 0 {
 		// Receive the chip ID
-		chipId, err = hif.t.ReadRegister(_NMI_CHIPID)
+		chipId, err := hif.t.ReadRegister(_NMI_CHIPID)
 		if err != nil {
 			return 0, err
 		}
@@ -290,19 +358,111 @@ func (hif *Hif) GetChipId() (uint32, error) {
 			}
 		}
 
-		chipId &= ^uint32(0x0F0000)
-		chipId |= 0x050000
+		// WINC1500 parts report the revision of the product family in bits 16-19, which is always 5
+		if chipId&0xF00000 == 0x100000 {
+			chipId &= ^uint32(0x0F0000)
+			chipId |= 0x050000
+		}
+
+		hif.chipId = chipId
 	}
 
-	return chipId, nil
+	return hif.chipId, nil
+}
+
+// GetMacAddress reads the MAC address that the firmware uses. The address programmed into the OTP memory of the chip is
+// read instead when otp is set. A nil address is returned if the OTP memory holds no address.
+func (hif *Hif) GetMacAddress(otp bool) (mac []byte, err error) {
+	hif.mutex.Lock()
+	defer hif.mutex.Unlock()
+
+	if err = hif.chipWakeInternal(); err != nil {
+		return nil, err
+	}
+	defer hif.chipSleepInternal()
+
+	// The general purpose register points to the application info of the firmware
+	var reg uint32
+	if reg, err = hif.t.ReadRegister(_rNMI_GP_REG_2); err != nil {
+		return nil, err
+	}
+
+	info := make([]byte, 8)
+	if err = hif.t.ReadBlock(reg|0x30000, info); err != nil {
+		return nil, err
+	}
+
+	addresses := binary.LittleEndian.Uint32(info)
+	address := addresses & 0xFFFF
+	if otp {
+		if address = addresses >> 16; address == 0 {
+			return nil, nil
+		}
+	}
+
+	mac = make([]byte, 6)
+	if err = hif.t.ReadBlock(address|0x30000, mac); err != nil {
+		return nil, err
+	}
+
+	return mac, nil
+}
+
+// GetFirmwareRevision reads the revision info the firmware keeps in its application info together with the content of
+// the revision register. A nil revision is returned if the firmware publishes no revision info.
+func (hif *Hif) GetFirmwareRevision() (rev []byte, revReg uint32, err error) {
+	hif.mutex.Lock()
+	defer hif.mutex.Unlock()
+
+	if err = hif.chipWakeInternal(); err != nil {
+		return nil, 0, err
+	}
+	defer hif.chipSleepInternal()
+
+	if revReg, err = hif.t.ReadRegister(_NMI_REV_REG); err != nil {
+		return nil, 0, err
+	}
+
+	var reg uint32
+	if reg, err = hif.t.ReadRegister(_rNMI_GP_REG_2); err != nil {
+		return nil, 0, err
+	} else if reg == 0 {
+		return nil, revReg, nil
+	}
+
+	info := make([]byte, 8)
+	if err = hif.t.ReadBlock(reg|0x30000, info); err != nil {
+		return nil, 0, err
+	}
+
+	address := binary.LittleEndian.Uint32(info[4:]) & 0xFFFF
+	if address == 0 {
+		return nil, revReg, nil
+	}
+
+	rev = make([]byte, 40)
+	if err = hif.t.ReadBlock(address|0x30000, rev); err != nil {
+		return nil, 0, err
+	}
+
+	return rev, revReg, nil
 }
 
 func (hif *Hif) Receive(address uint32, data []byte, done bool) (err error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
+	// The packet may be received after the interrupt was handled
+	if err = hif.chipWakeInternal(); err != nil {
+		return err
+	}
+	defer hif.chipSleepInternal()
+
 	if address == 0 || data == nil || len(data) == 0 {
 		if done {
+			if hif.tracer != nil {
+				hif.tracer.TraceReceive(address, nil, done)
+			}
 			return hif.setRxDone()
 		} else {
 			return errOperationFailed
@@ -310,8 +470,8 @@ func (hif *Hif) Receive(address uint32, data []byte, done bool) (err error) {
 	}
 
 	// NOTE: This is set by the ISR
-	rxSize := volatile.LoadUint32(&rxSize)
-	rxAddress := volatile.LoadUint32(&rxAddress)
+	rxSize := atomic.LoadUint32(&hif.rxSize)
+	rxAddress := atomic.LoadUint32(&hif.rxAddress)
 	length := uint32(len(data))
 
 	if length > rxSize {
@@ -327,6 +487,10 @@ func (hif *Hif) Receive(address uint32, data []byte, done bool) (err error) {
 		return err
 	}
 
+	if hif.tracer != nil {
+		hif.tracer.TraceReceive(address, data, done)
+	}
+
 	// Is this the last packet?
 	if (rxAddress+rxSize)-(address+length) <= 0 || done {
 		// Set RX done
@@ -343,6 +507,10 @@ func (hif *Hif) Send(group GroupId, opcode OpcodeId, control, data []byte, offse
 	debug.DEBUG("HIF: Send - BEGIN")
 	defer debug.DEBUG("HIF: Send - END")
 
+	if hif.tracer != nil {
+		hif.tracer.TraceSend(group, opcode, control, data, offset)
+	}
+
 	strHif := hifHeader{
 		groupId: byte(group),
 		opcode:  byte(opcode) & ^byte(_NBIT7),
@@ -362,6 +530,7 @@ func (hif *Hif) Send(group GroupId, opcode OpcodeId, control, data []byte, offse
 		if err = hif.chipWakeInternal(); err != nil {
 			return err
 		}
+		defer hif.chipSleepInternal()
 
 		var reg uint32
 		reg |= uint32(group)
@@ -451,17 +620,12 @@ func (hif *Hif) Send(group GroupId, opcode OpcodeId, control, data []byte, offse
 			}
 		} else {
 			debug.DEBUG("HIF: Failed to receive DMA address")
-			if err = hif.ChipSleep(); err != nil {
-				debug.DEBUG("HIF: hif.ChipSleep - %v", err)
-				return err
-			}
 			return errBadMemoryAlloc
 		}
 	} else {
 		return errMessageTooLong
 	}
 
-	hif.chipSleepInternal()
 	return
 }
 
@@ -491,7 +655,7 @@ func (hif *Hif) waitForBootrom() error {
 	}
 
 	// Write the version info
-	err := hif.t.WriteRegister(_NMI_STATE_REG, _VERSION)
+	err := hif.t.WriteRegister(_NMI_STATE_REG, hif.regs.version)
 	if err != nil {
 		return err
 	}
@@ -600,7 +764,7 @@ func (hif *Hif) readHeader(address uint32) (group GroupId, opcode OpcodeId, leng
 }
 
 func (hif *Hif) setRxDone() (err error) {
-	volatile.StoreUint8(&rxDone, 0)
+	atomic.StoreUint32(&hif.rxDone, 0)
 	var reg uint32
 	if reg, err = hif.t.ReadRegister(_WIFI_HOST_RCV_CTRL_0); err != nil {
 		return err
@@ -615,6 +779,25 @@ func (hif *Hif) setRxDone() (err error) {
 	return
 }
 
+// InterruptPending reports whether the chip raised the RX interrupt. This lets the host poll the chip when the
+// interrupt line is not connected.
+func (hif *Hif) InterruptPending() (pending bool, err error) {
+	hif.mutex.Lock()
+	defer hif.mutex.Unlock()
+
+	if err = hif.chipWakeInternal(); err != nil {
+		return
+	}
+	defer hif.chipSleepInternal()
+
+	var reg uint32
+	if reg, err = hif.t.ReadRegister(_WIFI_HOST_RCV_CTRL_0); err != nil {
+		return
+	}
+
+	return reg&0x1 != 0, nil
+}
+
 func (hif *Hif) Isr() (err error) {
 	// Lock the mutex to prevent other goroutines from sending frames will this ISR is processing
 	var once sync.Once
@@ -639,7 +822,7 @@ func (hif *Hif) Isr() (err error) {
 	}
 
 	// Set the RX done state
-	volatile.StoreUint8(&rxDone, 1)
+	atomic.StoreUint32(&hif.rxDone, 1)
 
 	// Set the size
 	size = uint16(reg>>2) & 0xFFF
@@ -650,8 +833,8 @@ func (hif *Hif) Isr() (err error) {
 			return
 		}
 
-		volatile.StoreUint32(&rxAddress, address)
-		volatile.StoreUint32(&rxSize, uint32(size))
+		atomic.StoreUint32(&hif.rxAddress, address)
+		atomic.StoreUint32(&hif.rxSize, uint32(size))
 
 		// Receive the header
 		var group GroupId
@@ -661,6 +844,10 @@ func (hif *Hif) Isr() (err error) {
 			return
 		}
 
+		if hif.tracer != nil {
+			hif.tracer.TraceIsr(group, opcode, length, address)
+		}
+
 		if size-length > 4 {
 			// The packet is likely corrupted
 			return errOperationFailed
@@ -672,7 +859,7 @@ func (hif *Hif) Isr() (err error) {
 		// Execute the respective callback functions based on the header
 		var data any
 		var callbackErr error
-		if fn := callbacks[group]; fn != nil {
+		if fn := hif.callbacks[group]; fn != nil {
 			if data, callbackErr = fn(opcode, length-8, address+8); callbackErr == nil && data != nil {
 				// Emit event
 				e := Event{
@@ -681,27 +868,26 @@ func (hif *Hif) Isr() (err error) {
 					Data:   data,
 				}
 
-				for i := 0; i < len(hif.eventChannels); i++ {
-					eventChan := hif.eventChannels[i]
+				hif.eventMutex.Lock()
+				for _, eventChan := range hif.eventChannels {
 					select {
 					case eventChan <- e:
 						// Proceed
 					default:
-						// Attempt to drain the channel
-						if _, ok := <-eventChan; !ok {
-							// This channel is closed. Remove from list
-							hif.eventChannels = append(hif.eventChannels[:i], hif.eventChannels[i+1:]...)
-						} else {
-							// Signal the event
-							eventChan <- e
+						// Drop the oldest event. The channel has room afterwards since events are only sent while
+						// holding the mutex.
+						select {
+						case <-eventChan:
+						default:
 						}
+						eventChan <- e
 					}
 				}
+				hif.eventMutex.Unlock()
 			}
 		}
 
-		volatile.LoadUint8(&rxDone)
-		if rxDone != 0 {
+		if atomic.LoadUint32(&hif.rxDone) != 0 {
 			if err = hif.setRxDone(); err != nil {
 				return err
 			}
@@ -717,6 +903,11 @@ func (hif *Hif) SetGPIODirection(gpio, direction uint8) (err error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
+	if err = hif.chipWakeInternal(); err != nil {
+		return
+	}
+	defer hif.chipSleepInternal()
+
 	var value uint32
 	if value, err = hif.t.ReadRegister(0x20108); err != nil {
 		return
@@ -735,6 +926,11 @@ func (hif *Hif) SetGPIOValue(gpio, state uint8) (err error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
+	if err = hif.chipWakeInternal(); err != nil {
+		return
+	}
+	defer hif.chipSleepInternal()
+
 	var value uint32
 	if value, err = hif.t.ReadRegister(0x20100); err != nil {
 		return
@@ -753,6 +949,11 @@ func (hif *Hif) GetGPIOValue(gpio uint8) (state uint8, err error) {
 	hif.mutex.Lock()
 	defer hif.mutex.Unlock()
 
+	if err = hif.chipWakeInternal(); err != nil {
+		return
+	}
+	defer hif.chipSleepInternal()
+
 	var value uint32
 	if value, err = hif.t.ReadRegister(0x142C); err != nil {
 		return
@@ -28,7 +28,6 @@ import (
 	"sync"
 	"time"
 
-	"machine"
 	"tinygo.org/x/drivers"
 
 	"github.com/waj334/tinygo-winc/debug"
@@ -42,6 +41,7 @@ type transport struct {
 	spiMutex sync.Mutex
 
 	crcEnabled bool
+	packetSize int
 }
 
 func (t *transport) init() (err error) {
@@ -115,7 +115,7 @@ func (t *transport) initPacketSize() error {
 	}
 
 	result &= ^uint32(0x7 << 4)
-	switch dataPacketSize {
+	switch t.packetSize {
 	case 256:
 		result |= 0 << 4
 	case 512:
@@ -347,7 +347,7 @@ func (t *transport) writeBlockInternal(address uint32, data []byte) (err error)
 	cmd := commandPacket{}
 	cmd.dmaExtendedWrite(address, len(buf))
 
-	pkt := dataPacket(data)
+	pkt := dataPacket(buf)
 
 	for retry := 0; retry < 10; retry++ {
 		debug.DEBUG("Transport: Attempt %v - BEGIN", retry)
@@ -407,7 +407,7 @@ func (t *transport) internalReset() (err error) {
 }
 
 func (t *transport) chipSelect(enable bool) {
-	if t.cs != machine.NoPin && t.cs != nil {
+	if isValidPin(t.cs) {
 		if enable {
 			t.cs.High()
 		} else {
@@ -0,0 +1,219 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+// requestKey identifies the reply to a request. Replies that don't carry a socket or session ID use -1 and 0
+// respectively. Name holds the hostname echoed by DNS replies and index the index of scan results. BLE replies are
+// told apart by message and operation, see bleRequestKey.
+type requestKey struct {
+	group     protocol.GroupId
+	opcode    protocol.OpcodeId
+	socket    int8
+	session   uint16
+	name      string
+	index     uint8
+	message   uint16
+	operation uint8
+}
+
+// pendingRequest is a request sent to the firmware that awaits its reply.
+type pendingRequest struct {
+	key   requestKey
+	reply chan any
+}
+
+// pendingRequests matches the replies of the firmware to the outstanding requests. Replies are matched to the oldest
+// request with the same key. Requests are removed once the caller stops waiting so that replies arriving afterwards
+// match no request and are dropped.
+//
+// NOTE: Replies without a session ID, like the connect reply, can't be told apart from the reply to the next request
+// with the same key.
+type pendingRequests struct {
+	requests []*pendingRequest
+	stale    uint32
+	mutex    sync.Mutex
+}
+
+// add registers a request. Call this before sending the request so that the reply cannot arrive first.
+func (p *pendingRequests) add(key requestKey) *pendingRequest {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	r := &pendingRequest{
+		key:   key,
+		reply: make(chan any, 1),
+	}
+
+	p.requests = append(p.requests, r)
+	return r
+}
+
+// remove unregisters a request that could not be sent.
+func (p *pendingRequests) remove(r *pendingRequest) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.removeInternal(r)
+}
+
+func (p *pendingRequests) removeInternal(r *pendingRequest) {
+	for i := range p.requests {
+		if p.requests[i] == r {
+			p.requests = append(p.requests[:i], p.requests[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolve passes the reply to the request it belongs to. This never blocks the interrupt handler.
+func (p *pendingRequests) resolve(key requestKey, reply any) {
+	if !p.notify(key, reply) {
+		// Nobody asked for this reply
+		atomic.AddUint32(&p.stale, 1)
+	}
+}
+
+// notify passes the reply to the request with the key if there is one. Use this for unsolicited packets that also
+// complete a request, since they are not counted as stale when no request is waiting.
+func (p *pendingRequests) notify(key requestKey, reply any) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, r := range p.requests {
+		if r.key == key {
+			p.requests = append(p.requests[:i], p.requests[i+1:]...)
+			r.reply <- reply
+			return true
+		}
+	}
+
+	return false
+}
+
+// waiting reports whether a request awaits its reply.
+func (p *pendingRequests) waiting() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.requests) > 0
+}
+
+// wait blocks until the reply to the request arrives or the context is done.
+func (p *pendingRequests) wait(ctx context.Context, r *pendingRequest) (any, error) {
+	if ctx.Err() == nil {
+		select {
+		case reply := <-r.reply:
+			if err, ok := reply.(error); ok {
+				// The request was dropped by a reset
+				return nil, err
+			}
+			return reply, nil
+		case <-ctx.Done():
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	select {
+	case <-r.reply:
+		// The reply arrived too late
+		atomic.AddUint32(&p.stale, 1)
+	default:
+		p.removeInternal(r)
+	}
+
+	return nil, contextError(ctx)
+}
+
+// reset drops all outstanding requests. Callers still waiting for a reply are woken with net.ErrClosed.
+func (p *pendingRequests) reset() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, r := range p.requests {
+		r.reply <- net.ErrClosed
+	}
+
+	p.requests = nil
+}
+
+// request sends a request to the firmware and waits for the reply identified by key. The mutex of the driver is held
+// while sending but not while waiting, so the caller must not hold it.
+func (w *WINC) request(ctx context.Context, key requestKey, opcode protocol.OpcodeId, control, data []byte, offset uint16) (any, error) {
+	w.mutex.Lock()
+	r := w.pending.add(key)
+	err := w.hif.Send(key.group, opcode, control, data, offset)
+	w.mutex.Unlock()
+
+	if err != nil {
+		w.pending.remove(r)
+		return nil, err
+	}
+
+	return w.pending.wait(ctx, r)
+}
+
+// send sends a command that has no reply while holding the mutex of the driver. Calls that wait for replies use this
+// for their follow-up commands.
+func (w *WINC) send(group protocol.GroupId, opcode protocol.OpcodeId, control, data []byte, offset uint16) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.hif.Send(group, opcode, control, data, offset)
+}
+
+// StaleReplies returns the number of replies that were dropped because they arrived after the caller stopped waiting
+// or because they matched no outstanding request.
+func (w *WINC) StaleReplies() uint32 {
+	return atomic.LoadUint32(&w.pending.stale)
+}
+
+// contextError returns ErrOperationTimeout if the deadline of the context passed. Otherwise, the error of the context
+// is returned.
+func contextError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrOperationTimeout
+	}
+
+	return ctx.Err()
+}
+
+// replyContext returns the context used by calls that don't accept one.
+func (w *WINC) replyContext() (context.Context, context.CancelFunc) {
+	if w.ReplyTimeout > 0 {
+		return context.WithTimeout(context.Background(), w.ReplyTimeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
@@ -0,0 +1,377 @@
+//go:build winc_ble
+
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/waj334/tinygo-winc/debug"
+)
+
+// BLE API messages
+const (
+	bleGattmAddSvcReq        uint16 = 0x2C00
+	bleGattmAddSvcRsp        uint16 = 0x2C01
+	bleGattmAttSetValueReq   uint16 = 0x2C0A
+	bleGattmAttSetValueRsp   uint16 = 0x2C0B
+	bleGattcSendEvtCmd       uint16 = 0x3010
+	bleGattcWriteReqInd      uint16 = 0x3015
+	bleGattcWriteCfm         uint16 = 0x3016
+	bleGapmCmpEvt            uint16 = 0x3400
+	bleGapmCancelCmd         uint16 = 0x3403
+	bleGapmStartAdvertiseCmd uint16 = 0x340D
+	bleGapmStartScanCmd      uint16 = 0x340E
+	bleGapmAdvReportInd      uint16 = 0x3410
+	bleGapcConnectionReqInd  uint16 = 0x3801
+	bleGapcConnectionCfm     uint16 = 0x3802
+	bleGapcDisconnectInd     uint16 = 0x3803
+
+	bleTaskGattm uint16 = 11
+	bleTaskGattc uint16 = 12
+	bleTaskGapm  uint16 = 13
+	bleTaskGapc  uint16 = 14
+
+	bleOpCancel                byte = 0x03
+	bleOpAdvertiseNonConnected byte = 0x0E
+	bleOpAdvertiseUndirected   byte = 0x0F
+	bleOpNotify                byte = 0x12
+
+	bleStatusOK         byte = 0x00
+	bleErrDisallowed    byte = 0x43
+	bleErrCanceled      byte = 0x44
+	bleAttInvalidHandle byte = 0x01
+
+	bleHeaderLength = 9
+)
+
+// BLEPeripheral is a BLE device advertising within range of the simulated chip.
+type BLEPeripheral struct {
+	Address     net.HardwareAddr
+	AddressType byte
+	RSSI        int8
+	Data        []byte
+}
+
+type bleAttribute struct {
+	uuid      [16]byte
+	perm      uint16
+	maxLength uint16
+	value     []byte
+}
+
+type bleState struct {
+	// Devices within range. They stay in range across resets.
+	peripherals []BLEPeripheral
+
+	// Running air operation of the GAP manager, zero when idle
+	operation byte
+
+	advData      []byte
+	scanResponse []byte
+
+	// Attributes of the GATT server. The handle of an attribute is its index plus one.
+	attributes []bleAttribute
+
+	// Task of the host that owns the GATT server
+	appTask uint16
+
+	central       net.HardwareAddr
+	confirmed     bool
+	writes        map[uint16][]byte
+	notifications [][]byte
+}
+
+// reset powers the BLE controller up.
+func (b *bleState) reset() {
+	*b = bleState{peripherals: b.peripherals}
+}
+
+// AddBLEPeripheral adds a device to the advertisements received by BLE scans.
+func (c *Chip) AddBLEPeripheral(p BLEPeripheral) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.ble.peripherals = append(c.ble.peripherals, p)
+}
+
+// BLEAdvertising returns the payloads advertised by the BLE controller and whether it is advertising.
+func (c *Chip) BLEAdvertising() (data, scanResponse []byte, active bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ble.operation != bleOpAdvertiseNonConnected && c.ble.operation != bleOpAdvertiseUndirected {
+		return nil, nil, false
+	}
+
+	return c.ble.advData, c.ble.scanResponse, true
+}
+
+// ConnectBLE connects a central with the address. The host must be advertising connectable. It returns false
+// otherwise.
+func (c *Chip) ConnectBLE(address net.HardwareAddr) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ble.operation != bleOpAdvertiseUndirected || c.ble.central != nil {
+		return false
+	}
+
+	// Advertising ends with the connection
+	c.postBLE(bleGapmCmpEvt, c.ble.appTask, bleTaskGapm, []byte{c.ble.operation, bleStatusOK})
+	c.ble.operation = 0
+	c.ble.central = address
+	c.ble.confirmed = false
+	c.ble.writes = map[uint16][]byte{}
+
+	params := make([]byte, 9)
+	for i := range address {
+		params[3+i] = address[5-i]
+	}
+
+	c.postBLE(bleGapcConnectionReqInd, c.ble.appTask, bleTaskGapc, params)
+	return true
+}
+
+// BLEConnected reports whether a central is connected and the host confirmed the connection.
+func (c *Chip) BLEConnected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ble.central != nil && c.ble.confirmed
+}
+
+// DisconnectBLE disconnects the central.
+func (c *Chip) DisconnectBLE() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ble.central == nil {
+		return
+	}
+
+	c.ble.central = nil
+	c.postBLE(bleGapcDisconnectInd, c.ble.appTask, bleTaskGapc, []byte{0, 0, 0x13, 0})
+}
+
+// WriteBLE makes the connected central write the attribute with the handle. The value is stored once the host
+// confirms the write. It returns false if no central is connected.
+func (c *Chip) WriteBLE(handle uint16, value []byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.ble.central == nil {
+		return false
+	}
+
+	c.ble.writes[handle] = value
+
+	params := make([]byte, 6, 6+len(value))
+	binary.LittleEndian.PutUint16(params[0:], handle)
+	binary.LittleEndian.PutUint16(params[4:], uint16(len(value)))
+	c.postBLE(bleGattcWriteReqInd, c.ble.appTask, bleTaskGattc, append(params, value...))
+	return true
+}
+
+// ReadBLE returns the value of the attribute with the handle.
+func (c *Chip) ReadBLE(handle uint16) []byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if handle == 0 || int(handle) > len(c.ble.attributes) {
+		return nil
+	}
+
+	return c.ble.attributes[handle-1].value
+}
+
+// BLENotifications returns the values the host notified the connected central of.
+func (c *Chip) BLENotifications() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ble.notifications
+}
+
+// postBLE posts a message of a task of the BLE stack to the host.
+func (c *Chip) postBLE(id, dest, src uint16, params []byte) {
+	length := bleHeaderLength + len(params)
+	msg := make([]byte, 2+bleHeaderLength, 2+length)
+	binary.LittleEndian.PutUint16(msg[0:], uint16(length))
+	msg[2] = 0x05
+	binary.LittleEndian.PutUint16(msg[3:], id)
+	binary.LittleEndian.PutUint16(msg[5:], dest)
+	binary.LittleEndian.PutUint16(msg[7:], src)
+	binary.LittleEndian.PutUint16(msg[9:], uint16(len(params)))
+	c.post(groupWifi, opWifiRespBleApiRecv, append(msg, params...))
+}
+
+// bleData returns the payload of a length-prefixed advertising data field.
+func bleData(field []byte) []byte {
+	length := int(field[0])
+	if length > len(field)-1 {
+		length = len(field) - 1
+	}
+
+	return append([]byte(nil), field[1:1+length]...)
+}
+
+// bleRequest handles a message the host sent to the BLE stack.
+func (c *Chip) bleRequest(payload []byte) {
+	if !c.isWINC3400() {
+		debug.DEBUG("SIMULATOR: Chip %#x has no BLE controller", c.ChipID)
+		return
+	}
+
+	if len(payload) < 2+bleHeaderLength {
+		return
+	}
+
+	id := binary.LittleEndian.Uint16(payload[3:])
+	dest := binary.LittleEndian.Uint16(payload[5:])
+	src := binary.LittleEndian.Uint16(payload[7:])
+	params := payload[2+bleHeaderLength:]
+	if n := int(binary.LittleEndian.Uint16(payload[9:])); n < len(params) {
+		params = params[:n]
+	}
+
+	switch id {
+	case bleGapmStartAdvertiseCmd:
+		if len(params) < 72 {
+			return
+		}
+
+		if c.ble.operation != 0 {
+			c.postBLE(bleGapmCmpEvt, src, bleTaskGapm, []byte{params[0], bleErrDisallowed})
+			return
+		}
+
+		c.ble.operation = params[0]
+		c.ble.appTask = src
+		c.ble.advData = bleData(params[8:40])
+		c.ble.scanResponse = bleData(params[40:72])
+	case bleGapmStartScanCmd:
+		if len(params) < 8 {
+			return
+		}
+
+		if c.ble.operation != 0 {
+			c.postBLE(bleGapmCmpEvt, src, bleTaskGapm, []byte{params[0], bleErrDisallowed})
+			return
+		}
+
+		// Report every peripheral once. The scan lasts until the host cancels it.
+		c.ble.operation = params[0]
+		for _, p := range c.ble.peripherals {
+			report := make([]byte, 41)
+			report[1] = p.AddressType
+			for i := range p.Address {
+				report[2+i] = p.Address[5-i]
+			}
+			report[8] = byte(copy(report[9:40], p.Data))
+			report[40] = byte(p.RSSI)
+			c.postBLE(bleGapmAdvReportInd, src, bleTaskGapm, report)
+		}
+	case bleGapmCancelCmd:
+		if c.ble.operation == 0 {
+			c.postBLE(bleGapmCmpEvt, src, bleTaskGapm, []byte{bleOpCancel, bleErrDisallowed})
+			return
+		}
+
+		c.postBLE(bleGapmCmpEvt, src, bleTaskGapm, []byte{c.ble.operation, bleErrCanceled})
+		c.postBLE(bleGapmCmpEvt, src, bleTaskGapm, []byte{bleOpCancel, bleStatusOK})
+		c.ble.operation = 0
+	case bleGattmAddSvcReq:
+		if len(params) < 22 || len(params) < 22+20*int(params[5]) {
+			return
+		}
+
+		start := uint16(len(c.ble.attributes) + 1)
+		c.ble.appTask = binary.LittleEndian.Uint16(params[2:])
+
+		service := bleAttribute{}
+		copy(service.uuid[:], params[6:22])
+		c.ble.attributes = append(c.ble.attributes, service)
+
+		for i := 0; i < int(params[5]); i++ {
+			a := params[22+20*i:]
+
+			attribute := bleAttribute{
+				perm:      binary.LittleEndian.Uint16(a[16:]),
+				maxLength: binary.LittleEndian.Uint16(a[18:]),
+			}
+			copy(attribute.uuid[:], a[:16])
+			c.ble.attributes = append(c.ble.attributes, attribute)
+		}
+
+		rsp := make([]byte, 4)
+		binary.LittleEndian.PutUint16(rsp[0:], start)
+		c.postBLE(bleGattmAddSvcRsp, src, bleTaskGattm, rsp)
+	case bleGattmAttSetValueReq:
+		if len(params) < 4 {
+			return
+		}
+
+		handle := binary.LittleEndian.Uint16(params[0:])
+		length := int(binary.LittleEndian.Uint16(params[2:]))
+
+		rsp := make([]byte, 4)
+		binary.LittleEndian.PutUint16(rsp[0:], handle)
+		if handle == 0 || int(handle) > len(c.ble.attributes) || length > len(params)-4 {
+			rsp[2] = bleAttInvalidHandle
+		} else {
+			c.ble.attributes[handle-1].value = append([]byte(nil), params[4:4+length]...)
+		}
+
+		c.postBLE(bleGattmAttSetValueRsp, src, bleTaskGattm, rsp)
+	case bleGattcSendEvtCmd:
+		if len(params) < 8 || params[0] != bleOpNotify || c.ble.central == nil || dest>>8 != 0 {
+			return
+		}
+
+		length := int(binary.LittleEndian.Uint16(params[6:]))
+		if length > len(params)-8 {
+			length = len(params) - 8
+		}
+
+		c.ble.notifications = append(c.ble.notifications, append([]byte(nil), params[8:8+length]...))
+	case bleGapcConnectionCfm:
+		if c.ble.central != nil {
+			c.ble.confirmed = true
+		}
+	case bleGattcWriteCfm:
+		if len(params) < 3 {
+			return
+		}
+
+		handle := binary.LittleEndian.Uint16(params[0:])
+		value, ok := c.ble.writes[handle]
+		delete(c.ble.writes, handle)
+		if ok && params[2] == bleStatusOK && handle > 0 && int(handle) <= len(c.ble.attributes) {
+			c.ble.attributes[handle-1].value = value
+		}
+	default:
+		debug.DEBUG("SIMULATOR: Unhandled BLE message %#x", id)
+	}
+}
@@ -0,0 +1,660 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package simulator emulates a WINC1500 or WINC3400 module behind its SPI interface so that the driver can be exercised on a host
+// without any hardware attached. The simulated chip implements drivers.SPI and provides the pins the driver expects.
+package simulator
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/waj334/tinygo-winc/debug"
+	"github.com/waj334/tinygo-winc/hal"
+)
+
+const (
+	// DefaultChipID is the chip ID reported by a WINC1500 rev 3A0
+	DefaultChipID = 0x1503A0
+
+	// WINC3400ChipID is the chip ID reported by a WINC3400 rev D0
+	WINC3400ChipID = 0x3000D0
+
+	hostTxBuffer = 0x30000
+	hostRxBuffer = 0x40000
+
+	// Application info of the firmware followed by the working and the OTP MAC address
+	appInfoOffset = 0xF000
+	macOffset     = appInfoOffset + 0x10
+	otpMacOffset  = appInfoOffset + 0x20
+	revOffset     = appInfoOffset + 0x30
+	rxSlotSize    = 0x1000
+	rxSlotCount   = 4
+)
+
+type Chip struct {
+	// ChipID is the value reported by the chip ID register. Set it before the driver is initialized.
+	ChipID uint32
+
+	// MACAddress is the address of the station interface programmed into the OTP memory. The OTP memory holds no
+	// address when nil. Set it before the driver is initialized.
+	MACAddress net.HardwareAddr
+
+	// IPConfig is the configuration handed to the driver when the station connects to a network
+	IPConfig IPConfig
+
+	// Firmware describes the firmware image the chip boots. Set it before the driver is initialized.
+	Firmware Firmware
+
+	// SystemTime is the time the SNTP client of the firmware synchronized to. The clock is not synchronized when zero.
+	SystemTime time.Time
+
+	// DHCP selects how the address assignment ends once the station connects. Set it before connecting.
+	DHCP DHCPOutcome
+
+	// DefaultConnectSuccess makes a successful default connect also answer with a zero result code before the state
+	// change. Set it before connecting.
+	DefaultConnectSuccess bool
+
+	cs     hal.SoftwarePin
+	enable hal.SoftwarePin
+	reset  hal.SoftwarePin
+	irq    hal.SoftwareInterruptPin
+
+	registers map[uint32]uint32
+	memory    map[uint32]byte
+	halted    bool
+
+	// Version word the host wrote before starting the firmware
+	hostVersion uint32
+
+	bus spiBus
+
+	// HIF mailbox state
+	queue    [][]byte
+	inflight bool
+	claimed  bool
+	rxSlot   int
+
+	// Firmware state
+	networks []AccessPoint
+	wps      *wpsConfig
+	stored   []storedCredentials
+	injected [][]byte
+	hosts    map[string]net.IP
+	handlers map[string]func(net.Conn)
+	sockets  [maxSocket]*socket
+	wifi     wifiState
+	ble      bleState
+	peerPort uint16
+
+	// Packets the firmware would post to the host are dropped while this returns true
+	dropFilter func(group, opcode byte) bool
+
+	// Packets the firmware would post to the host are held back while this returns true
+	holdFilter func(group, opcode byte) bool
+	held       [][]byte
+
+	mutex sync.Mutex
+}
+
+// AccessPoint is a network within range of the simulated chip.
+type AccessPoint struct {
+	SSID string
+	// Passphrase is empty for open networks
+	Passphrase string
+	// Username makes the network a WPA2-Enterprise network. Passphrase is then the password of the user, but any
+	// client certificate is accepted.
+	Username string
+	BSSID    [6]byte
+	RSSI     int8
+	Channel  byte
+}
+
+type DHCPOutcome uint8
+
+const (
+	// DHCPSuccess hands out IPConfig
+	DHCPSuccess DHCPOutcome = iota
+	// DHCPFailure reports that no DHCP server answered
+	DHCPFailure
+	// DHCPConflict reports that another host uses the address in IPConfig
+	DHCPConflict
+)
+
+type IPConfig struct {
+	IP        net.IP
+	Gateway   net.IP
+	DNS       net.IP
+	Mask      net.IP
+	LeaseTime uint32
+}
+
+// Firmware describes a firmware image.
+type Firmware struct {
+	Version          [3]byte
+	MinDriverVersion [3]byte
+
+	// HifInfo is the host interface version published by firmware 19.6.0 and later. The block number is held in bits
+	// 14-15, the major number in bits 8-13 and the minor number in bits 0-7.
+	HifInfo uint16
+
+	BuildDate string
+	BuildTime string
+
+	// Legacy firmware publishes only its version word in the revision register
+	Legacy bool
+}
+
+// DefaultFirmware is the firmware image booted by chips returned from New.
+var DefaultFirmware = Firmware{
+	Version:          [3]byte{19, 7, 7},
+	MinDriverVersion: [3]byte{19, 3, 0},
+	HifInfo:          2<<14 | 1<<8 | 3, // Block 2, version 1.3
+	BuildDate:        "Jan 20 2022",
+	BuildTime:        "10:31:09",
+}
+
+// WINC3400Firmware is a firmware image for the WINC3400.
+var WINC3400Firmware = Firmware{
+	Version:          [3]byte{1, 4, 6},
+	MinDriverVersion: [3]byte{1, 3, 0},
+	HifInfo:          2<<14 | 1<<8 | 3, // Block 2, version 1.3
+	BuildDate:        "Mar 14 2022",
+	BuildTime:        "16:02:45",
+}
+
+// New returns a powered simulated chip with its default configuration.
+func New() *Chip {
+	c := &Chip{
+		ChipID:     DefaultChipID,
+		Firmware:   DefaultFirmware,
+		MACAddress: net.HardwareAddr{0xF8, 0xF0, 0x05, 0x00, 0x00, 0x01},
+		IPConfig: IPConfig{
+			IP:        net.IPv4(192, 168, 1, 10).To4(),
+			Gateway:   net.IPv4(192, 168, 1, 1).To4(),
+			DNS:       net.IPv4(192, 168, 1, 1).To4(),
+			Mask:      net.IPv4(255, 255, 255, 0).To4(),
+			LeaseTime: 86400,
+		},
+		hosts:    map[string]net.IP{},
+		handlers: map[string]func(net.Conn){},
+		peerPort: 49152,
+	}
+
+	// The chip is powered until the host drives either pin low
+	c.enable.High()
+	c.reset.High()
+	c.enable.ResetTransitions()
+	c.reset.ResetTransitions()
+	c.enable.OnChange(c.powerChanged)
+	c.reset.OnChange(c.powerChanged)
+
+	c.powerOn()
+
+	return c
+}
+
+// CS returns the chip select pin.
+func (c *Chip) CS() *hal.SoftwarePin {
+	return &c.cs
+}
+
+// IRQ returns the interrupt line of the chip.
+func (c *Chip) IRQ() *hal.SoftwareInterruptPin {
+	return &c.irq
+}
+
+// EnablePin returns the chip enable pin.
+func (c *Chip) EnablePin() *hal.SoftwarePin {
+	return &c.enable
+}
+
+// ResetPin returns the reset pin.
+func (c *Chip) ResetPin() *hal.SoftwarePin {
+	return &c.reset
+}
+
+// AddNetwork registers an access point that the station can connect to. An empty passphrase describes an open
+// network.
+func (c *Chip) AddNetwork(ssid, passphrase string) {
+	c.mutex.Lock()
+	n := len(c.networks)
+	c.mutex.Unlock()
+
+	c.AddAccessPoint(AccessPoint{
+		SSID:       ssid,
+		Passphrase: passphrase,
+		BSSID:      [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, byte(n + 1)},
+		RSSI:       -45,
+		Channel:    1,
+	})
+}
+
+// AddAccessPoint registers an access point that is found by scans and that the station can connect to.
+func (c *Chip) AddAccessPoint(ap AccessPoint) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for i := range c.networks {
+		if c.networks[i].SSID == ap.SSID {
+			c.networks[i] = ap
+			return
+		}
+	}
+
+	c.networks = append(c.networks, ap)
+}
+
+func (c *Chip) findNetwork(ssid string) (AccessPoint, bool) {
+	for _, ap := range c.networks {
+		if ap.SSID == ssid {
+			return ap, true
+		}
+	}
+
+	return AccessPoint{}, false
+}
+
+// EnableWPS makes the access point named ssid answer WPS requests. Push button requests always succeed while PIN
+// requests must present pin. WPS requests stay pending until they are cancelled while WPS is not enabled.
+func (c *Chip) EnableWPS(ssid, pin string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.wps = &wpsConfig{
+		ssid: ssid,
+		pin:  pin,
+	}
+}
+
+// WPSActive reports whether the station is waiting for an access point to answer a WPS request.
+func (c *Chip) WPSActive() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.wpsActive
+}
+
+// Provisioning reports whether the firmware serves its provisioning page and the domain the page is served under.
+func (c *Chip) Provisioning() (domain string, active bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.provisionDomain, c.wifi.provisionDomain != ""
+}
+
+// Provision submits the credentials of the network named ssid on the provisioning page. The firmware leaves
+// provisioning mode afterwards. It returns false if the firmware is not in provisioning mode.
+func (c *Chip) Provision(ssid, passphrase string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.wifi.provisionDomain == "" {
+		return false
+	}
+
+	info := make([]byte, 100)
+	copy(info[0:32], ssid)
+	copy(info[33:97], passphrase)
+	info[98] = authOpen
+	if len(passphrase) > 0 {
+		info[98] = authWpaPsk
+	}
+
+	c.wifi.apEnabled = false
+	c.wifi.provisionDomain = ""
+	c.post(groupWifi, opWifiRespProvisionInfo, info)
+	return true
+}
+
+// PowerSave returns the power-save mode the host selected, whether the chip wakes up for broadcast traffic and the
+// listen interval in beacon periods. The listen interval is zero until the host sets one.
+func (c *Chip) PowerSave() (mode int, receiveBroadcast bool, listenInterval uint16) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return int(c.wifi.psMode), c.wifi.psBroadcast, c.wifi.listenInterval
+}
+
+// LastDoze returns the duration of the last sleep the host requested in manual power-save mode.
+func (c *Chip) LastDoze() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.lastDoze
+}
+
+// AsleepAccesses returns the number of times the host accessed the chip in power-save mode without waking it up first.
+func (c *Chip) AsleepAccesses() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.asleepAccesses
+}
+
+// WakeUps returns the number of times the host woke the chip up in power-save mode.
+func (c *Chip) WakeUps() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.wakeUps
+}
+
+// Disconnect makes the access point drop the connection of the station.
+func (c *Chip) Disconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.wifi.connected {
+		c.wifi.connected = false
+		c.postStateChanged(wifiDisconnected, 0)
+	}
+}
+
+// ReceiveFrame passes an 802.11 frame received on air to the station. The frame is delivered to the host if the
+// station is in monitor mode and the frame passes its filter. It returns whether the frame was delivered.
+func (c *Chip) ReceiveFrame(frame []byte, rssi int8) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	filter := c.wifi.monitor
+	if filter == nil || len(frame) < 24 {
+		return false
+	}
+
+	frameType := frame[0] & 0x0C
+	subtype := frame[0] & 0xF0
+
+	var destination, source, bssid [6]byte
+	copy(destination[:], frame[4:10])
+	copy(source[:], frame[10:16])
+	copy(bssid[:], frame[16:22])
+
+	match := func(filter, addr [6]byte) bool {
+		return filter == [6]byte{} || filter == addr
+	}
+
+	if (filter.frameType != 0xFF && filter.frameType != frameType) ||
+		(filter.subtype != 0xFF && filter.subtype != subtype) || !match(filter.source, source) ||
+		!match(filter.destination, destination) || !match(filter.bssid, bssid) {
+		return false
+	}
+
+	data := frame
+	if filter.headerOnly {
+		data = frame[:24]
+	}
+
+	info := make([]byte, 36, 36+len(data))
+	info[0] = frameType
+	info[1] = subtype
+	info[4] = 24
+	copy(info[6:12], source[:])
+	copy(info[12:18], destination[:])
+	copy(info[18:24], bssid[:])
+	binary.LittleEndian.PutUint16(info[24:], uint16(len(data)))
+	binary.LittleEndian.PutUint16(info[26:], uint16(len(frame)))
+	binary.LittleEndian.PutUint32(info[28:], 54000)
+	info[32] = byte(rssi)
+
+	c.post(groupWifi, opWifiRespRxPacket, append(info, data...))
+	return true
+}
+
+// InjectedFrames returns the raw frames the host transmitted.
+func (c *Chip) InjectedFrames() [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([][]byte(nil), c.injected...)
+}
+
+// SNTP returns the SNTP configuration of the firmware.
+func (c *Chip) SNTP() (server string, useDHCP, enabled bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.sntpServer, c.wifi.sntpUseDHCP, !c.wifi.sntpDisabled
+}
+
+// DeviceName returns the hostname the host set for the DHCP client. It is empty until the host sets one.
+func (c *Chip) DeviceName() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.wifi.deviceName
+}
+
+// HostVersion returns the version word the host announced before starting the firmware. The version of the host driver
+// is held in the upper half.
+func (c *Chip) HostVersion() uint32 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hostVersion
+}
+
+// StoredCredentials returns the SSIDs of the networks whose credentials the firmware stored in its flash.
+func (c *Chip) StoredCredentials() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ssids := make([]string, 0, len(c.stored))
+	for _, s := range c.stored {
+		ssids = append(ssids, s.ssid)
+	}
+
+	return ssids
+}
+
+// AddHost registers a hostname that the firmware resolves to ip.
+func (c *Chip) AddHost(hostname string, ip net.IP) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hosts[hostname] = ip.To4()
+}
+
+// DropReplies makes the firmware silently drop the packets it posts to the host for which fn returns true. This
+// simulates lost replies. Pass nil to deliver all packets again.
+func (c *Chip) DropReplies(fn func(group, opcode byte) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.dropFilter = fn
+}
+
+// HoldReplies makes the firmware hold back the packets it posts to the host for which fn returns true. They are posted
+// late, right before the next packet for which fn returns false. Pass nil to deliver all packets in order again.
+func (c *Chip) HoldReplies(fn func(group, opcode byte) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.holdFilter = fn
+}
+
+func (c *Chip) powerChanged(bool) {
+	on := c.enable.Get() && c.reset.Get()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !on {
+		c.halted = true
+	} else if c.halted {
+		c.powerOn()
+	}
+}
+
+// powerOn resets the chip into the state that the bootrom leaves it in.
+func (c *Chip) powerOn() {
+	// Drop all connections
+	for _, s := range c.sockets {
+		if s != nil {
+			s.close()
+		}
+	}
+
+	c.halted = false
+	c.bus = spiBus{}
+	c.queue = nil
+	c.held = nil
+	c.inflight = false
+	c.claimed = false
+	c.rxSlot = 0
+	c.sockets = [maxSocket]*socket{}
+	c.wifi = wifiState{}
+	c.ble.reset()
+
+	c.memory = map[uint32]byte{}
+	c.registers = map[uint32]uint32{
+		regChipID:          c.ChipID,
+		regEfuse:           0x80000000,
+		regBootrom:         finishBootrom,
+		regSpiProtocolConf: 0x2C, // CRC enabled, 1024 byte data packets
+		regGpReg2:          appInfoOffset,
+	}
+
+	appInfo := make([]byte, 8)
+	binary.LittleEndian.PutUint32(appInfo, macOffset)
+	if len(c.MACAddress) == 6 {
+		binary.LittleEndian.PutUint32(appInfo, otpMacOffset<<16|macOffset)
+		c.writeMemory(hostTxBuffer+otpMacOffset, c.MACAddress)
+	}
+	c.writeFirmwareRevision(appInfo)
+	c.writeMemory(hostTxBuffer+appInfoOffset, appInfo)
+	c.setStationMAC(c.MACAddress)
+}
+
+// writeFirmwareRevision publishes the revision info of the firmware and links it into the application info.
+func (c *Chip) writeFirmwareRevision(appInfo []byte) {
+	fw := c.Firmware
+	version := uint32(fw.MinDriverVersion[0])<<24 | uint32(fw.MinDriverVersion[1]&0xF)<<20 |
+		uint32(fw.MinDriverVersion[2]&0xF)<<16 | uint32(fw.Version[0])<<8 | uint32(fw.Version[1]&0xF)<<4 |
+		uint32(fw.Version[2]&0xF)
+
+	if fw.Legacy {
+		c.registers[regRevision] = version
+		return
+	}
+
+	c.registers[regRevision] = uint32(fw.HifInfo)
+
+	rev := make([]byte, 40)
+	copy(rev[4:7], fw.Version[:])
+	copy(rev[7:10], fw.MinDriverVersion[:])
+	copy(rev[10:21], fw.BuildDate)
+	copy(rev[22:30], fw.BuildTime)
+	c.writeMemory(hostTxBuffer+revOffset, rev)
+
+	binary.LittleEndian.PutUint32(appInfo[4:], revOffset)
+}
+
+// setStationMAC changes the working MAC address of the firmware.
+func (c *Chip) setStationMAC(mac net.HardwareAddr) {
+	c.wifi.mac = make(net.HardwareAddr, 6)
+	copy(c.wifi.mac, mac)
+	c.writeMemory(hostTxBuffer+macOffset, c.wifi.mac)
+}
+
+func (c *Chip) readRegister(address uint32) uint32 {
+	return c.registers[address]
+}
+
+func (c *Chip) writeRegister(address, value uint32) {
+	switch address {
+	case regSpiProtocolConf:
+		c.registers[address] = value
+	case regWakeClock:
+		if c.wifi.psMode != psNone && value&0x2 != 0 && c.registers[address]&0x2 == 0 {
+			c.wifi.wakeUps++
+		}
+
+		c.registers[address] = value
+		_, clocksEnable, _ := c.wakeRegisters()
+		if value&0x2 != 0 {
+			c.registers[clocksEnable] = 0x4
+		} else {
+			c.registers[clocksEnable] = 0
+		}
+	case regBootrom:
+		c.registers[address] = value
+		if value == startFirmware {
+			c.hostVersion = c.registers[regState]
+			c.registers[regState] = finishInitState
+		}
+	case regHostRcvCtrl0:
+		c.rxDone(value)
+	case regHostRcvCtrl2:
+		if value&0x2 != 0 {
+			// Allocate the DMA buffer for the incoming HIF packet
+			c.registers[regHostRcvCtrl4] = hostTxBuffer
+			value &^= 0x2
+		}
+		c.registers[address] = value
+	case regHostRcvCtrl3:
+		c.registers[address] = value
+		if value&0x2 != 0 {
+			c.receive(value >> 2)
+		}
+	case regGlobalReset:
+		// The host does not wait for a response to this write
+	default:
+		c.registers[address] = value
+	}
+}
+
+// isWINC3400 reports whether the chip ID belongs to the WINC3400 family, which has a BLE controller.
+func (c *Chip) isWINC3400() bool {
+	return c.ChipID&0xFFFFF000 == 0x300000
+}
+
+// wakeRegisters returns the addresses of the wake-up handshake registers of the chip family.
+func (c *Chip) wakeRegisters() (hostCortComm, clocksEnable, cortHostComm uint32) {
+	if c.isWINC3400() {
+		return regHostCortComm3400, regClocksEnable3400, regCortHostComm3400
+	}
+
+	return regHostCortComm, regClocksEnable, regCortHostComm
+}
+
+// access records accesses of the host to address while the chip may doze. Only the registers of the wake-up handshake
+// are reachable then.
+func (c *Chip) access(address uint32) {
+	if c.wifi.psMode == psNone || c.registers[regWakeClock]&0x2 != 0 {
+		return
+	}
+
+	hostCortComm, clocksEnable, cortHostComm := c.wakeRegisters()
+	switch address {
+	case regWakeClock, hostCortComm, clocksEnable, cortHostComm:
+		return
+	}
+
+	debug.DEBUG("SIMULATOR: Host accessed %#x while the chip may doze", address)
+	c.wifi.asleepAccesses++
+}
+
+func (c *Chip) readMemory(address uint32, length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = c.memory[address+uint32(i)]
+	}
+	return data
+}
+
+func (c *Chip) writeMemory(address uint32, data []byte) {
+	for i, b := range data {
+		c.memory[address+uint32(i)] = b
+	}
+}
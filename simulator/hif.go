@@ -0,0 +1,120 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"encoding/binary"
+
+	"github.com/waj334/tinygo-winc/debug"
+)
+
+const hifHeaderLength = 8
+
+// receive processes the HIF packet the host placed in the DMA buffer at address.
+func (c *Chip) receive(address uint32) {
+	header := c.readMemory(address, hifHeaderLength)
+	group := header[0]
+	opcode := header[1] &^ 0x80
+	length := binary.LittleEndian.Uint16(header[2:])
+
+	if length < hifHeaderLength {
+		debug.DEBUG("SIMULATOR: Dropping malformed HIF packet with length %v", length)
+		return
+	}
+
+	payload := c.readMemory(address+hifHeaderLength, int(length)-hifHeaderLength)
+	c.request(group, opcode, payload)
+}
+
+// post queues a HIF packet for the host.
+func (c *Chip) post(group, opcode byte, payload []byte) {
+	if c.dropFilter != nil && c.dropFilter(group, opcode) {
+		debug.DEBUG("SIMULATOR: Dropping reply group=%v opcode=%#x", group, opcode)
+		return
+	}
+
+	frame := make([]byte, hifHeaderLength, hifHeaderLength+len(payload))
+	frame[0] = group
+	frame[1] = opcode
+	binary.LittleEndian.PutUint16(frame[2:], uint16(hifHeaderLength+len(payload)))
+	frame = append(frame, payload...)
+
+	if c.holdFilter != nil {
+		if c.holdFilter(group, opcode) {
+			debug.DEBUG("SIMULATOR: Holding back reply group=%v opcode=%#x", group, opcode)
+			c.held = append(c.held, frame)
+			return
+		}
+
+		// The held packets overtake this one
+		c.queue = append(c.queue, c.held...)
+		c.held = nil
+	}
+
+	c.queue = append(c.queue, frame)
+	c.deliver()
+}
+
+// deliver moves the next queued HIF packet into the receive buffer and raises the interrupt line.
+func (c *Chip) deliver() {
+	if c.inflight || c.halted || len(c.queue) == 0 {
+		return
+	}
+
+	frame := c.queue[0]
+	c.queue = c.queue[1:]
+
+	// Rotate through the receive slots so that the payload of the previous packet stays readable for a while
+	address := uint32(hostRxBuffer + c.rxSlot*rxSlotSize)
+	c.rxSlot = (c.rxSlot + 1) % rxSlotCount
+
+	c.writeMemory(address, frame)
+	c.registers[regHostRcvCtrl1] = address
+	c.registers[regHostRcvCtrl0] = uint32(len(frame))<<2 | 0x1
+
+	c.inflight = true
+	c.claimed = false
+	c.irq.Trigger()
+}
+
+// rxDone handles host writes to the receive control register.
+func (c *Chip) rxDone(value uint32) {
+	if c.inflight && value&0x1 == 0 {
+		// The host cleared the interrupt and now owns the packet
+		c.claimed = true
+	}
+
+	if value&0x2 != 0 {
+		if c.inflight && c.claimed {
+			c.inflight = false
+			c.claimed = false
+			c.registers[regHostRcvCtrl0] = 0
+			c.deliver()
+		}
+		return
+	}
+
+	c.registers[regHostRcvCtrl0] = value
+}
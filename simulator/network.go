@@ -0,0 +1,177 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// pipeBuffer is one direction of an in-memory connection. Writes never block so that the firmware can forward data
+// without waiting on the remote end.
+type pipeBuffer struct {
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	data     []byte
+	closed   bool
+	deadline time.Time
+	timer    *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mutex)
+	return b
+}
+
+func (b *pipeBuffer) close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// conn is one end of an in-memory stream connection.
+type conn struct {
+	rx     *pipeBuffer
+	tx     *pipeBuffer
+	local  net.Addr
+	remote net.Addr
+
+	closeMutex sync.Mutex
+	closed     bool
+}
+
+// newPipe returns both ends of a buffered in-memory connection between local and remote.
+func newPipe(local, remote net.Addr) (*conn, *conn) {
+	a := newPipeBuffer()
+	b := newPipeBuffer()
+
+	return &conn{rx: a, tx: b, local: local, remote: remote},
+		&conn{rx: b, tx: a, local: remote, remote: local}
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	c.rx.mutex.Lock()
+	defer c.rx.mutex.Unlock()
+
+	for len(c.rx.data) == 0 {
+		if c.isClosed() {
+			return 0, net.ErrClosed
+		} else if c.rx.closed {
+			return 0, io.EOF
+		} else if !c.rx.deadline.IsZero() && !time.Now().Before(c.rx.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		c.rx.cond.Wait()
+	}
+
+	n := copy(p, c.rx.data)
+	c.rx.data = c.rx.data[n:]
+
+	return n, nil
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	c.tx.mutex.Lock()
+	defer c.tx.mutex.Unlock()
+
+	if c.isClosed() {
+		return 0, net.ErrClosed
+	} else if c.tx.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	c.tx.data = append(c.tx.data, p...)
+	c.tx.cond.Broadcast()
+
+	return len(p), nil
+}
+
+func (c *conn) Close() error {
+	c.closeMutex.Lock()
+	if c.closed {
+		c.closeMutex.Unlock()
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.closeMutex.Unlock()
+
+	c.rx.close()
+	c.tx.close()
+
+	return nil
+}
+
+func (c *conn) isClosed() bool {
+	c.closeMutex.Lock()
+	defer c.closeMutex.Unlock()
+	return c.closed
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.rx.mutex.Lock()
+	defer c.rx.mutex.Unlock()
+
+	if c.rx.timer != nil {
+		c.rx.timer.Stop()
+		c.rx.timer = nil
+	}
+
+	c.rx.deadline = t
+	if !t.IsZero() {
+		rx := c.rx
+		rx.timer = time.AfterFunc(time.Until(t), func() {
+			rx.mutex.Lock()
+			defer rx.mutex.Unlock()
+			rx.cond.Broadcast()
+		})
+	}
+
+	c.rx.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline has no effect since writes never block.
+func (c *conn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,60 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+// Register map of the chip as seen by the host driver
+const (
+	regWakeClock       = 0x01
+	regHostCortComm    = 0x0B
+	regClocksEnable    = 0x0F
+	regCortHostComm    = 0x10
+	regChipID          = 0x1000
+	regEfuse           = 0x1014
+	regHostRcvCtrl3    = 0x106c
+	regHostRcvCtrl0    = 0x1070
+	regHostRcvCtrl2    = 0x1078
+	regHostRcvCtrl1    = 0x1084
+	regState           = 0x108c
+	regGlobalReset     = 0x1400
+	regPinMux0         = 0x1408
+	regInterruptEnable = 0x1a00
+	regRfRevID         = 0x13F4
+	regGpReg1          = 0x14A0
+	regGpReg2          = 0xc0008
+	regSpiProtocolConf = 0xE824
+	regBootrom         = 0xc000c
+	regHostRcvCtrl4    = 0x150400
+	regRevision        = 0x207ac
+	regWaitForHost     = 0x207bc
+
+	// The WINC3400 moved the wake-up handshake registers
+	regHostCortComm3400 = 0x0E
+	regClocksEnable3400 = 0x13
+	regCortHostComm3400 = 0x14
+
+	finishBootrom   = 0x10add09e
+	startFirmware   = 0xef522f61
+	finishInitState = 0x02532636
+)
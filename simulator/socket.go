@@ -0,0 +1,479 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/waj334/tinygo-winc/debug"
+)
+
+// IP configuration opcodes
+const (
+	opIpReqStaticIpConf byte = 0x0A
+	opIpReqEnableDhcp   byte = 0x0B
+	opIpReqDisableDhcp  byte = 0x0C
+)
+
+// Socket opcodes
+const (
+	opSocketBind       byte = 0x41
+	opSocketListen     byte = 0x42
+	opSocketAccept     byte = 0x43
+	opSocketConnect    byte = 0x44
+	opSocketSend       byte = 0x45
+	opSocketRecv       byte = 0x46
+	opSocketSendTo     byte = 0x47
+	opSocketRecvFrom   byte = 0x48
+	opSocketClose      byte = 0x49
+	opSocketDnsResolve byte = 0x4A
+	opSocketSslConnect byte = 0x4B
+	opSocketSslSend    byte = 0x4C
+	opSocketSslRecv    byte = 0x4D
+	opSocketSslClose   byte = 0x4E
+	opSocketSetSockOpt byte = 0x4F
+	opSocketSslSetOpt  byte = 0x51
+	opSocketSslBind    byte = 0x54
+)
+
+// Socket error codes
+const (
+	sockErrAddrAlreadyInUse int8 = -2
+	sockErrInvalidArg       int8 = -6
+	sockErrConnAborted      int8 = -12
+	sockErrTimeout          int8 = -13
+)
+
+const (
+	maxTcpSocket = 7
+	maxSocket    = 11
+
+	// Offset of the application data within a TX packet (TCP_TX_PACKET_OFFSET + M2M_HIF_HDR_OFFSET)
+	appDataOffset = 88
+
+	// Largest amount of data handed to the host with a single receive reply
+	maxRecvChunk = 1400
+
+	recvReplyLength = 16
+	infiniteTimeout = 0xFFFFFFFF
+)
+
+var ErrConnectionRefused = errors.New("connection refused")
+
+type socket struct {
+	fd        int8
+	port      uint16
+	listening bool
+
+	conn   *conn
+	remote *net.TCPAddr
+	rx     []byte
+	eof    bool
+
+	recv *recvRequest
+}
+
+type recvRequest struct {
+	opcode  byte
+	session uint16
+	size    uint16
+	timer   *time.Timer
+}
+
+func (s *socket) close() {
+	if s.recv != nil && s.recv.timer != nil {
+		s.recv.timer.Stop()
+	}
+	s.recv = nil
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// Handle registers a handler for connections the module opens to address. The address is given as "ip:port". The
+// handler is called in its own goroutine with the remote end of the connection.
+func (c *Chip) Handle(address string, handler func(net.Conn)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handlers[address] = handler
+}
+
+// Dial opens a connection to a socket listening on the module at port and returns the remote end of the connection.
+func (c *Chip) Dial(port uint16) (net.Conn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var listener *socket
+	for _, s := range c.sockets {
+		if s != nil && s.listening && s.port == port {
+			listener = s
+			break
+		}
+	}
+
+	if listener == nil {
+		return nil, ErrConnectionRefused
+	}
+
+	// The firmware picks a free socket for the new connection
+	fd := c.freeSocket()
+	if fd < 0 {
+		return nil, ErrConnectionRefused
+	}
+
+	peer := &net.TCPAddr{
+		IP:   c.IPConfig.Gateway.To4(),
+		Port: int(c.peerPort),
+	}
+	c.peerPort++
+
+	local := &net.TCPAddr{
+		IP:   c.IPConfig.IP.To4(),
+		Port: int(port),
+	}
+
+	moduleEnd, remoteEnd := newPipe(local, peer)
+	s := &socket{
+		fd:     fd,
+		port:   port,
+		conn:   moduleEnd,
+		remote: peer,
+	}
+	c.sockets[fd] = s
+	go c.readLoop(s)
+
+	// Accept reply
+	payload := make([]byte, 12)
+	copy(payload[:8], sockaddrBytes(peer))
+	payload[8] = byte(listener.fd)
+	payload[9] = byte(fd)
+	binary.LittleEndian.PutUint16(payload[10:], appDataOffset)
+	c.post(groupIP, opSocketAccept, payload)
+
+	return remoteEnd, nil
+}
+
+func (c *Chip) freeSocket() int8 {
+	for i := 0; i < maxTcpSocket; i++ {
+		if c.sockets[i] == nil {
+			return int8(i)
+		}
+	}
+	return -1
+}
+
+func (c *Chip) socketAt(fd int8) *socket {
+	if fd < 0 || fd >= maxSocket {
+		return nil
+	}
+
+	if c.sockets[fd] == nil {
+		c.sockets[fd] = &socket{fd: fd}
+	}
+
+	return c.sockets[fd]
+}
+
+func (c *Chip) socketRequest(opcode byte, payload []byte) {
+	switch opcode {
+	case opIpReqStaticIpConf:
+		if len(payload) < 24 {
+			return
+		}
+
+		ip := func(b []byte) net.IP {
+			return net.IPv4(b[0], b[1], b[2], b[3]).To4()
+		}
+
+		c.IPConfig = IPConfig{
+			IP:      ip(payload[0:4]),
+			Gateway: ip(payload[4:8]),
+			DNS:     ip(payload[8:12]),
+			Mask:    ip(payload[16:20]),
+		}
+	case opIpReqEnableDhcp:
+		c.wifi.dhcpDisabled = false
+	case opIpReqDisableDhcp:
+		c.wifi.dhcpDisabled = true
+	case opSocketBind, opSocketSslBind:
+		if len(payload) < 12 {
+			return
+		}
+
+		fd := int8(payload[8])
+		session := binary.LittleEndian.Uint16(payload[10:])
+		port := binary.BigEndian.Uint16(payload[2:4])
+
+		status := int8(0)
+		for _, other := range c.sockets {
+			if other != nil && other.fd != fd && other.listening && other.port == port {
+				status = sockErrAddrAlreadyInUse
+			}
+		}
+
+		if s := c.socketAt(fd); s == nil {
+			status = sockErrInvalidArg
+		} else if status == 0 {
+			s.port = port
+		}
+
+		c.post(groupIP, opcode, replyBytes(fd, status, session))
+	case opSocketListen:
+		if len(payload) < 4 {
+			return
+		}
+
+		fd := int8(payload[0])
+		session := binary.LittleEndian.Uint16(payload[2:])
+
+		status := int8(0)
+		if s := c.socketAt(fd); s == nil {
+			status = sockErrInvalidArg
+		} else {
+			s.listening = true
+		}
+
+		c.post(groupIP, opSocketListen, replyBytes(fd, status, session))
+	case opSocketConnect, opSocketSslConnect:
+		if len(payload) < 12 {
+			return
+		}
+
+		fd := int8(payload[8])
+		remote := &net.TCPAddr{
+			IP:   net.IP(append([]byte{}, payload[4:8]...)),
+			Port: int(binary.BigEndian.Uint16(payload[2:4])),
+		}
+
+		s := c.socketAt(fd)
+		handler := c.handlers[remote.String()]
+		if s == nil || handler == nil {
+			c.post(groupIP, opcode, replyBytes(fd, sockErrConnAborted, 0))
+			return
+		}
+
+		local := &net.TCPAddr{
+			IP:   c.IPConfig.IP.To4(),
+			Port: int(c.peerPort),
+		}
+		c.peerPort++
+
+		moduleEnd, remoteEnd := newPipe(local, remote)
+		s.conn = moduleEnd
+		s.remote = remote
+		s.rx = nil
+		s.eof = false
+		go c.readLoop(s)
+		go handler(remoteEnd)
+
+		// NOTE: The last two bytes of the connect reply carry the application data offset
+		reply := make([]byte, 4)
+		reply[0] = byte(fd)
+		binary.LittleEndian.PutUint16(reply[2:], appDataOffset)
+		c.post(groupIP, opcode, reply)
+	case opSocketSend, opSocketSslSend, opSocketSendTo:
+		if len(payload) < 16 {
+			return
+		}
+
+		fd := int8(payload[0])
+		size := binary.LittleEndian.Uint16(payload[2:])
+		session := binary.LittleEndian.Uint16(payload[12:])
+
+		sent := int16(sockErrConnAborted)
+		if s := c.socketAt(fd); s != nil && s.conn != nil && int(size) <= len(payload) {
+			if _, err := s.conn.Write(payload[len(payload)-int(size):]); err == nil {
+				sent = int16(size)
+			}
+		}
+
+		reply := make([]byte, 8)
+		reply[0] = byte(fd)
+		binary.LittleEndian.PutUint16(reply[2:], uint16(sent))
+		binary.LittleEndian.PutUint16(reply[4:], session)
+		c.post(groupIP, opcode, reply)
+	case opSocketRecv, opSocketSslRecv, opSocketRecvFrom:
+		if len(payload) < 10 {
+			return
+		}
+
+		timeout := binary.LittleEndian.Uint32(payload[0:])
+		fd := int8(payload[4])
+
+		s := c.socketAt(fd)
+		if s == nil {
+			return
+		}
+
+		if s.recv != nil && s.recv.timer != nil {
+			s.recv.timer.Stop()
+		}
+
+		request := &recvRequest{
+			opcode:  opcode,
+			session: binary.LittleEndian.Uint16(payload[6:]),
+			size:    binary.LittleEndian.Uint16(payload[8:]),
+		}
+		s.recv = request
+
+		if timeout != infiniteTimeout {
+			request.timer = time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+				c.mutex.Lock()
+				defer c.mutex.Unlock()
+
+				if s.recv == request {
+					s.recv = nil
+					c.postRecv(s, request, int16(sockErrTimeout), nil)
+				}
+			})
+		}
+
+		c.completeRecv(s)
+	case opSocketClose, opSocketSslClose:
+		if len(payload) < 4 {
+			return
+		}
+
+		fd := int8(payload[0])
+		if fd >= 0 && fd < maxSocket && c.sockets[fd] != nil {
+			c.sockets[fd].close()
+			c.sockets[fd] = nil
+		}
+	case opSocketDnsResolve:
+		hostname := payload
+		for i, b := range payload {
+			if b == 0 {
+				hostname = payload[:i]
+				break
+			}
+		}
+
+		reply := make([]byte, 68)
+		copy(reply[:64], hostname)
+		if ip, ok := c.hosts[string(hostname)]; ok {
+			copy(reply[64:], ip)
+		} else if ip := net.ParseIP(string(hostname)).To4(); ip != nil {
+			copy(reply[64:], ip)
+		}
+		c.post(groupIP, opSocketDnsResolve, reply)
+	case opSocketSetSockOpt, opSocketSslSetOpt:
+		// Socket options have no effect on the simulated network
+	default:
+		debug.DEBUG("SIMULATOR: Unhandled socket opcode %#x", opcode)
+	}
+}
+
+// readLoop moves data the remote end writes into the receive buffer of the socket.
+func (c *Chip) readLoop(s *socket) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := s.conn.Read(buf)
+
+		c.mutex.Lock()
+		if s.fd < 0 || c.sockets[s.fd] != s {
+			c.mutex.Unlock()
+			return
+		}
+
+		s.rx = append(s.rx, buf[:n]...)
+		if err != nil {
+			s.eof = true
+		}
+		c.completeRecv(s)
+		c.mutex.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// completeRecv answers a pending receive request if the socket has data or the connection was closed.
+func (c *Chip) completeRecv(s *socket) {
+	request := s.recv
+	if request == nil {
+		return
+	}
+
+	if len(s.rx) > 0 {
+		n := len(s.rx)
+		if n > int(request.size) {
+			n = int(request.size)
+		}
+		if n > maxRecvChunk {
+			n = maxRecvChunk
+		}
+
+		data := s.rx[:n]
+		s.rx = s.rx[n:]
+		s.recv = nil
+		if request.timer != nil {
+			request.timer.Stop()
+		}
+
+		c.postRecv(s, request, int16(n), data)
+	} else if s.eof {
+		s.recv = nil
+		if request.timer != nil {
+			request.timer.Stop()
+		}
+
+		c.postRecv(s, request, int16(sockErrConnAborted), nil)
+	}
+}
+
+func (c *Chip) postRecv(s *socket, request *recvRequest, status int16, data []byte) {
+	payload := make([]byte, recvReplyLength, recvReplyLength+len(data))
+	if s.remote != nil {
+		copy(payload[:8], sockaddrBytes(s.remote))
+	}
+	binary.LittleEndian.PutUint16(payload[8:], uint16(status))
+	binary.LittleEndian.PutUint16(payload[10:], recvReplyLength)
+	payload[12] = byte(s.fd)
+	binary.LittleEndian.PutUint16(payload[14:], request.session)
+	payload = append(payload, data...)
+
+	c.post(groupIP, request.opcode, payload)
+}
+
+func replyBytes(fd, status int8, session uint16) []byte {
+	reply := make([]byte, 4)
+	reply[0] = byte(fd)
+	reply[1] = byte(status)
+	binary.LittleEndian.PutUint16(reply[2:], session)
+	return reply
+}
+
+func sockaddrBytes(addr *net.TCPAddr) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint16(buf[0:], 2) // AF_INET
+	binary.BigEndian.PutUint16(buf[2:], uint16(addr.Port))
+	copy(buf[4:], addr.IP.To4())
+	return buf
+}
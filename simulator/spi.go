@@ -0,0 +1,290 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+const (
+	cmdDmaWrite               byte = 0xC1
+	cmdDmaRead                byte = 0xC2
+	cmdRegisterInternalWrite  byte = 0xC3
+	cmdRegisterInternalRead   byte = 0xC4
+	cmdTransactionTermination byte = 0xC5
+	cmdRepeatDataPacket       byte = 0xC6
+	cmdDmaExtendedWrite       byte = 0xC7
+	cmdDmaExtendedRead        byte = 0xC8
+	cmdDmaSingleWordWrite     byte = 0xC9
+	cmdDmaSingleWordRead      byte = 0xCA
+	cmdSoftReset              byte = 0xCF
+
+	dataResponse byte = 0xC3
+)
+
+// spiBus holds the state of the SPI slave interface between two clocked bytes.
+type spiBus struct {
+	command []byte
+	out     []byte
+
+	// DMA extended write state
+	writing    bool
+	writeAddr  uint32
+	remaining  int
+	chunk      int
+	crcPending int
+}
+
+// Tx implements drivers.SPI.
+func (c *Chip) Tx(w, r []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	length := len(w)
+	if length == 0 {
+		length = len(r)
+	}
+
+	for i := 0; i < length; i++ {
+		var b byte
+		if i < len(w) {
+			b = w[i]
+		}
+
+		result := c.clock(b)
+		if i < len(r) {
+			r[i] = result
+		}
+	}
+
+	return nil
+}
+
+// Transfer implements drivers.SPI.
+func (c *Chip) Transfer(b byte) (byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.clock(b), nil
+}
+
+// clock shifts a single byte into the chip and returns the byte shifted out at the same time.
+func (c *Chip) clock(b byte) byte {
+	if c.halted {
+		return 0
+	}
+
+	bus := &c.bus
+
+	// Shift out any pending response bytes first
+	if len(bus.out) > 0 {
+		result := bus.out[0]
+		bus.out = bus.out[1:]
+		return result
+	}
+
+	if bus.writing {
+		c.writeData(b)
+		return 0
+	}
+
+	if len(bus.command) == 0 && commandLength(b) == 0 {
+		// Filler byte
+		return 0
+	}
+
+	bus.command = append(bus.command, b)
+
+	length := commandLength(bus.command[0])
+	if c.crcEnabled() {
+		length++
+	}
+
+	if len(bus.command) == length {
+		c.execute(bus.command)
+		bus.command = bus.command[:0]
+	}
+
+	return 0
+}
+
+func (c *Chip) execute(cmd []byte) {
+	bus := &c.bus
+	op := cmd[0]
+
+	switch op {
+	case cmdRegisterInternalRead:
+		clockless := cmd[1]&0x80 != 0
+		address := uint32(cmd[1]&0x7F)<<8 | uint32(cmd[2])
+		c.access(address)
+		c.respond(op)
+		c.sendData(le32(c.readRegister(address)), clockless)
+	case cmdDmaSingleWordRead:
+		c.access(be24(cmd[1:]))
+		c.respond(op)
+		c.sendData(le32(c.readRegister(be24(cmd[1:]))), false)
+	case cmdDmaExtendedRead:
+		c.access(be24(cmd[1:]))
+		c.respond(op)
+		c.sendData(c.readMemory(be24(cmd[1:]), int(be24(cmd[4:]))), false)
+	case cmdRegisterInternalWrite:
+		address := uint32(cmd[1]&0x7F)<<8 | uint32(cmd[2])
+		c.access(address)
+		c.respond(op)
+		c.writeRegister(address, be32(cmd[3:]))
+	case cmdDmaSingleWordWrite:
+		address := be24(cmd[1:])
+		c.access(address)
+		if address != regGlobalReset {
+			c.respond(op)
+		}
+		c.writeRegister(address, be32(cmd[4:]))
+	case cmdDmaExtendedWrite:
+		c.access(be24(cmd[1:]))
+		c.respond(op)
+		bus.writing = true
+		bus.writeAddr = be24(cmd[1:])
+		bus.remaining = int(be24(cmd[4:]))
+		bus.chunk = 0
+		bus.crcPending = 0
+	case cmdSoftReset, cmdTransactionTermination, cmdRepeatDataPacket:
+		// These commands are answered with an additional leading byte
+		bus.out = append(bus.out, 0)
+		c.respond(op)
+		if op == cmdSoftReset {
+			bus.writing = false
+		}
+	default:
+		bus.out = append(bus.out, op, byte(errUnsupportedCommand))
+	}
+}
+
+func (c *Chip) respond(op byte) {
+	c.bus.out = append(c.bus.out, op, 0)
+}
+
+// sendData queues data packets to be clocked out by the host.
+func (c *Chip) sendData(data []byte, clockless bool) {
+	bus := &c.bus
+	size := c.packetSize()
+
+	for offset := 0; offset < len(data); offset += size {
+		end := offset + size
+		if end > len(data) {
+			end = len(data)
+		}
+
+		// Data packet header
+		var header byte = 0xF2
+		if offset == 0 {
+			header = 0xF1
+		}
+		if end == len(data) {
+			header = 0xF3
+		}
+
+		bus.out = append(bus.out, header)
+		bus.out = append(bus.out, data[offset:end]...)
+
+		if c.crcEnabled() && !clockless {
+			bus.out = append(bus.out, 0, 0)
+		}
+	}
+}
+
+// writeData accepts a single byte of a DMA extended write.
+func (c *Chip) writeData(b byte) {
+	bus := &c.bus
+
+	switch {
+	case bus.chunk > 0:
+		c.memory[bus.writeAddr] = b
+		bus.writeAddr++
+		bus.remaining--
+		bus.chunk--
+
+		if bus.chunk == 0 && c.crcEnabled() {
+			bus.crcPending = 2
+			return
+		}
+	case bus.crcPending > 0:
+		bus.crcPending--
+	case b&0xF0 == 0xF0:
+		// Data packet header
+		bus.chunk = c.packetSize()
+		if bus.chunk > bus.remaining {
+			bus.chunk = bus.remaining
+		}
+		return
+	default:
+		return
+	}
+
+	if bus.chunk == 0 && bus.crcPending == 0 && bus.remaining == 0 {
+		bus.writing = false
+
+		// Data response
+		if c.crcEnabled() {
+			bus.out = append(bus.out, dataResponse, 0, 0)
+		} else {
+			bus.out = append(bus.out, 0, dataResponse, 0)
+		}
+	}
+}
+
+func (c *Chip) crcEnabled() bool {
+	return c.registers[regSpiProtocolConf]&0x0C != 0
+}
+
+func (c *Chip) packetSize() int {
+	return 256 << ((c.registers[regSpiProtocolConf] >> 4) & 0x7)
+}
+
+func commandLength(op byte) int {
+	switch op {
+	case cmdRegisterInternalRead, cmdDmaSingleWordRead, cmdSoftReset, cmdTransactionTermination,
+		cmdRepeatDataPacket:
+		return 4
+	case cmdDmaRead:
+		return 6
+	case cmdDmaWrite:
+		return 6
+	case cmdRegisterInternalWrite, cmdDmaExtendedWrite, cmdDmaExtendedRead:
+		return 7
+	case cmdDmaSingleWordWrite:
+		return 8
+	}
+	return 0
+}
+
+const errUnsupportedCommand = 0x01
+
+func le32(value uint32) []byte {
+	return []byte{byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)}
+}
+
+func be24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,549 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package simulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/waj334/tinygo-winc/debug"
+	"github.com/waj334/tinygo-winc/utilities"
+)
+
+// HIF groups
+const (
+	groupMain byte = iota
+	groupWifi
+	groupIP
+	groupHIF
+	groupOTA
+	groupSSL
+)
+
+// Wi-Fi opcodes
+const (
+	opWifiReqSetMacAddress    byte = 2
+	opWifiReqGetConnInfo      byte = 5
+	opWifiRespConnInfo        byte = 6
+	opWifiReqSetDeviceName    byte = 7
+	opWifiReqStartProvision   byte = 8
+	opWifiRespProvisionInfo   byte = 9
+	opWifiReqStopProvision    byte = 10
+	opWifiReqEnableSntp       byte = 12
+	opWifiReqDisableSntp      byte = 13
+	opWifiReqScan             byte = 16
+	opWifiRespScanDone        byte = 17
+	opWifiReqScanResult       byte = 18
+	opWifiRespScanResult      byte = 19
+	opWifiReqGetSysTime       byte = 26
+	opWifiRespGetSysTime      byte = 27
+	opWifiReqPassiveScan      byte = 35
+	opWifiReqConfigSntp       byte = 36
+	opWifiRespBleApiRecv      byte = 37
+	opWifiReqBleApiSend       byte = 38
+	opWifiReqDisconnect       byte = 43
+	opWifiRespConStateChanged byte = 44
+	opWifiReqSleep            byte = 45
+	opWifiReqWps              byte = 47
+	opWifiReqDisableWps       byte = 49
+	opWifiReqDhcpConf         byte = 50
+	opWifiRespIpConflict      byte = 52
+	opWifiReqEnableMonitor    byte = 53
+	opWifiReqDisableMonitor   byte = 54
+	opWifiRespRxPacket        byte = 55
+	opWifiReqSendWifiPacket   byte = 56
+	opWifiReqLsnInt           byte = 57
+	opWifiReqDoze             byte = 58
+	opWifiReqDefaultConnect   byte = 41
+	opWifiRespDefaultConnect  byte = 42
+	opWifiReqConn             byte = 59
+	opWifiIndConnParam        byte = 60
+	opWifiReqDhcpFailure      byte = 61
+	opWifiReqDeleteApId       byte = 62
+	opWifiReqEnableAP         byte = 70
+	opWifiReqDisableAP        byte = 71
+)
+
+// Connection error codes reported with a state change
+const (
+	wifiErrAuthFail   byte = 3
+	wifiErrApNotFound byte = 5
+)
+
+// Default connect error codes
+const (
+	defaultConnFail         int8 = -24
+	defaultConnScanMismatch int8 = -25
+	defaultConnEmptyList    int8 = -26
+)
+
+const (
+	wifiDisconnected byte = 0
+	wifiConnected    byte = 1
+
+	authOpen   byte = 1
+	authWpaPsk byte = 2
+	auth8021X  byte = 4
+
+	channelAll byte = 255
+
+	credStorageFlags byte = 0x03
+
+	wpsTriggerPin byte = 0
+
+	psNone   byte = 0
+	psManual byte = 4
+)
+
+// storedCredentials are the credentials of a network the firmware keeps in flash.
+type storedCredentials struct {
+	ssid    string
+	auth    byte
+	channel byte
+	data    []byte
+}
+
+// monitorFilter is the frame filter of monitor mode. 0xFF and zero addresses match any frame.
+type monitorFilter struct {
+	frameType   byte
+	subtype     byte
+	source      [6]byte
+	destination [6]byte
+	bssid       [6]byte
+	headerOnly  bool
+}
+
+type wpsConfig struct {
+	ssid string
+	pin  string
+}
+
+type wifiState struct {
+	connected bool
+	ssid      string
+	auth      byte
+	channel   byte
+	apEnabled bool
+	wpsActive bool
+
+	// Set when the host turned the DHCP client off
+	dhcpDisabled bool
+
+	// 802.1X credentials sent ahead of the connection request
+	connParam []byte
+
+	// Working MAC address of the station
+	mac net.HardwareAddr
+
+	// Hostname announced by the DHCP client
+	deviceName string
+
+	// Domain of the provisioning page while in provisioning mode
+	provisionDomain string
+
+	// Power-save configuration. The chip may doze while a mode is set and the host does not hold it awake.
+	psMode         byte
+	psBroadcast    bool
+	listenInterval uint16
+	lastDoze       time.Duration
+	asleepAccesses int
+	wakeUps        int
+
+	// Set while in monitor mode
+	monitor *monitorFilter
+
+	sntpServer   string
+	sntpUseDHCP  bool
+	sntpDisabled bool
+
+	// Access points found by the last scan
+	scanResults []AccessPoint
+}
+
+// request dispatches a HIF packet received from the host to the respective firmware service.
+func (c *Chip) request(group, opcode byte, payload []byte) {
+	switch group {
+	case groupWifi:
+		c.wifiRequest(opcode, payload)
+	case groupIP:
+		c.socketRequest(opcode, payload)
+	default:
+		debug.DEBUG("SIMULATOR: Unhandled request group=%v opcode=%#x", group, opcode)
+	}
+}
+
+func (c *Chip) wifiRequest(opcode byte, payload []byte) {
+	switch opcode {
+	case opWifiReqConn:
+		if len(payload) < 48 {
+			return
+		}
+
+		ssidLen := int(payload[4])
+		if ssidLen > 32 {
+			ssidLen = 32
+		}
+		credentials := storedCredentials{
+			ssid:    string(payload[5 : 5+ssidLen]),
+			auth:    payload[44],
+			channel: payload[3],
+			data:    append([]byte(nil), payload[48:]...),
+		}
+
+		if credentials.auth == auth8021X {
+			credentials.data, c.wifi.connParam = c.wifi.connParam, nil
+		}
+
+		ap, ok := c.findNetwork(credentials.ssid)
+		if !ok {
+			c.postStateChanged(wifiDisconnected, wifiErrApNotFound)
+			return
+		}
+
+		if !ap.authenticate(credentials.auth, credentials.data) {
+			c.postStateChanged(wifiDisconnected, wifiErrAuthFail)
+			return
+		}
+
+		if payload[2]&credStorageFlags != 0 {
+			c.deleteCredentials(credentials.ssid)
+			c.stored = append(c.stored, credentials)
+		}
+
+		c.connect(credentials)
+	case opWifiIndConnParam:
+		c.wifi.connParam = append([]byte(nil), payload...)
+	case opWifiReqDefaultConnect:
+		if len(c.stored) == 0 {
+			c.postDefaultConnect(defaultConnEmptyList)
+			return
+		}
+
+		code := defaultConnScanMismatch
+		for i := len(c.stored) - 1; i >= 0; i-- {
+			credentials := c.stored[i]
+			ap, ok := c.findNetwork(credentials.ssid)
+			if !ok {
+				continue
+			}
+
+			if ap.authenticate(credentials.auth, credentials.data) {
+				if c.DefaultConnectSuccess {
+					c.postDefaultConnect(0)
+				}
+				c.connect(credentials)
+				return
+			}
+			code = defaultConnFail
+		}
+
+		c.postDefaultConnect(code)
+	case opWifiReqDeleteApId:
+		if len(payload) < 36 {
+			return
+		}
+
+		if payload[0] == 0xFF {
+			c.stored = nil
+		} else if ssidLen := int(payload[0]); ssidLen <= 32 {
+			c.deleteCredentials(string(payload[1 : 1+ssidLen]))
+		}
+	case opWifiReqDisconnect:
+		if c.wifi.connected {
+			c.wifi.connected = false
+			c.postStateChanged(wifiDisconnected, 0)
+		}
+	case opWifiReqGetConnInfo:
+		info := make([]byte, 48)
+		if c.wifi.connected {
+			copy(info[:32], c.wifi.ssid)
+			info[33] = c.wifi.auth
+			copy(info[34:38], c.IPConfig.IP.To4())
+		}
+		copy(info[38:44], c.wifi.mac)
+		rssi := int8(-45)
+		info[44] = byte(rssi)
+		info[45] = c.wifi.channel
+		c.post(groupWifi, opWifiRespConnInfo, info)
+	case opWifiReqScan, opWifiReqPassiveScan:
+		if len(payload) < 4 {
+			return
+		}
+
+		channel := payload[0]
+		c.wifi.scanResults = nil
+		for _, ap := range c.networks {
+			if channel == channelAll || channel == ap.Channel {
+				c.wifi.scanResults = append(c.wifi.scanResults, ap)
+			}
+		}
+
+		c.post(groupWifi, opWifiRespScanDone, []byte{byte(len(c.wifi.scanResults)), 0, 0, 0})
+	case opWifiReqScanResult:
+		if len(payload) < 4 || int(payload[0]) >= len(c.wifi.scanResults) {
+			return
+		}
+
+		ap := c.wifi.scanResults[payload[0]]
+		result := make([]byte, 44)
+		result[0] = payload[0]
+		result[1] = byte(ap.RSSI)
+		result[2] = ap.authType()
+		result[3] = ap.Channel
+		copy(result[4:10], ap.BSSID[:])
+		copy(result[10:42], ap.SSID)
+		c.post(groupWifi, opWifiRespScanResult, result)
+	case opWifiReqWps:
+		if len(payload) < 12 {
+			return
+		}
+
+		if c.wps == nil {
+			// No access point answers until the request is cancelled
+			c.wifi.wpsActive = true
+			return
+		}
+
+		info := make([]byte, 100)
+		pin := string(bytes.TrimRight(payload[1:9], "\x00"))
+		if ap, ok := c.findNetwork(c.wps.ssid); ok && (payload[0] != wpsTriggerPin || pin == c.wps.pin) {
+			info[0] = ap.authType()
+			info[1] = ap.Channel
+			copy(info[2:34], ap.SSID)
+			copy(info[35:99], ap.Passphrase)
+		}
+		c.post(groupWifi, opWifiReqWps, info)
+	case opWifiReqDisableWps:
+		c.wifi.wpsActive = false
+	case opWifiReqEnableMonitor:
+		if len(payload) < 24 {
+			return
+		}
+
+		filter := &monitorFilter{
+			frameType:  payload[1],
+			subtype:    payload[2],
+			headerOnly: payload[21] != 0,
+		}
+		copy(filter.source[:], payload[3:9])
+		copy(filter.destination[:], payload[9:15])
+		copy(filter.bssid[:], payload[15:21])
+		c.wifi.monitor = filter
+	case opWifiReqDisableMonitor:
+		c.wifi.monitor = nil
+	case opWifiReqSendWifiPacket:
+		if len(payload) < 4 {
+			return
+		}
+
+		size := int(binary.LittleEndian.Uint16(payload[0:]))
+		if 4+size > len(payload) {
+			return
+		}
+		c.injected = append(c.injected, append([]byte(nil), payload[4:4+size]...))
+	case opWifiReqSetMacAddress:
+		if len(payload) < 8 {
+			return
+		}
+
+		c.setStationMAC(payload[:6])
+	case opWifiReqSetDeviceName:
+		if len(payload) < 48 {
+			return
+		}
+
+		c.wifi.deviceName = string(bytes.TrimRight(payload[:48], "\x00"))
+	case opWifiReqGetSysTime:
+		now := make([]byte, 8)
+		if !c.wifi.sntpDisabled && !c.SystemTime.IsZero() {
+			t := c.SystemTime.UTC()
+			binary.LittleEndian.PutUint16(now[0:], uint16(t.Year()))
+			now[2] = byte(t.Month())
+			now[3] = byte(t.Day())
+			now[4] = byte(t.Hour())
+			now[5] = byte(t.Minute())
+			now[6] = byte(t.Second())
+		}
+		c.post(groupWifi, opWifiRespGetSysTime, now)
+	case opWifiReqConfigSntp:
+		if len(payload) < 36 {
+			return
+		}
+
+		c.wifi.sntpServer = string(bytes.TrimRight(payload[:33], "\x00"))
+		c.wifi.sntpUseDHCP = payload[33] != 0
+	case opWifiReqEnableSntp:
+		c.wifi.sntpDisabled = false
+	case opWifiReqDisableSntp:
+		c.wifi.sntpDisabled = true
+	case opWifiReqStartProvision:
+		if len(payload) < 216 {
+			return
+		}
+
+		c.wifi.apEnabled = true
+		c.wifi.provisionDomain = string(bytes.TrimRight(payload[136:200], "\x00"))
+	case opWifiReqStopProvision:
+		if c.wifi.provisionDomain != "" {
+			c.wifi.apEnabled = false
+			c.wifi.provisionDomain = ""
+		}
+	case opWifiReqSleep:
+		if len(payload) < 4 {
+			return
+		}
+
+		c.wifi.psMode = payload[0]
+		c.wifi.psBroadcast = payload[1] != 0
+	case opWifiReqLsnInt:
+		if len(payload) < 4 {
+			return
+		}
+
+		c.wifi.listenInterval = binary.LittleEndian.Uint16(payload)
+	case opWifiReqDoze:
+		if len(payload) < 4 || c.wifi.psMode != psManual {
+			return
+		}
+
+		c.wifi.lastDoze = time.Duration(binary.LittleEndian.Uint32(payload)) * time.Millisecond
+	case opWifiReqBleApiSend:
+		c.bleRequest(payload)
+	case opWifiReqEnableAP:
+		c.wifi.apEnabled = true
+	case opWifiReqDisableAP:
+		c.wifi.apEnabled = false
+	default:
+		debug.DEBUG("SIMULATOR: Unhandled Wi-Fi opcode %v", opcode)
+	}
+}
+
+func (ap *AccessPoint) authType() byte {
+	if len(ap.Username) > 0 {
+		return auth8021X
+	} else if len(ap.Passphrase) > 0 {
+		return authWpaPsk
+	}
+
+	return authOpen
+}
+
+// authenticate checks the authentication data that followed a connection request.
+func (ap *AccessPoint) authenticate(auth byte, data []byte) bool {
+	if auth != ap.authType() {
+		return false
+	}
+
+	switch auth {
+	case authWpaPsk:
+		if len(data) < 108 {
+			return false
+		}
+
+		if data[105] != 0 {
+			// The host derived the key from the passphrase
+			return bytes.Equal(data[65:97], utilities.PBKDF2([]byte(ap.Passphrase), []byte(ap.SSID), 4096, 32))
+		}
+
+		passphraseLen := int(data[0])
+		if passphraseLen > 64 {
+			passphraseLen = 64
+		}
+		return string(data[1:1+passphraseLen]) == ap.Passphrase
+	case auth8021X:
+		if len(data) < 44 {
+			return false
+		}
+
+		flags := data[0]
+		userStart := int(data[3]) + int(data[1])
+		userEnd := userStart + int(data[2])
+		keyOffset := int(binary.LittleEndian.Uint16(data[4:]))
+		keyEnd := keyOffset + int(binary.LittleEndian.Uint16(data[6:]))
+		certificateLen := int(binary.LittleEndian.Uint16(data[10:]))
+		if userEnd > len(data) || keyEnd > len(data) || string(data[userStart:userEnd]) != ap.Username {
+			return false
+		}
+
+		if flags&0x02 != 0 {
+			// Any client certificate is trusted
+			return certificateLen > 0
+		}
+		return string(data[keyOffset:keyEnd]) == ap.Passphrase
+	}
+
+	return true
+}
+
+func (c *Chip) connect(credentials storedCredentials) {
+	c.wifi.connected = true
+	c.wifi.ssid = credentials.ssid
+	c.wifi.auth = credentials.auth
+	c.wifi.channel = credentials.channel
+
+	c.postStateChanged(wifiConnected, 0)
+	if c.wifi.dhcpDisabled {
+		return
+	}
+
+	switch c.DHCP {
+	case DHCPFailure:
+		c.post(groupWifi, opWifiReqDhcpFailure, nil)
+	case DHCPConflict:
+		c.post(groupWifi, opWifiRespIpConflict, c.IPConfig.IP.To4())
+	default:
+		c.post(groupWifi, opWifiReqDhcpConf, c.ipConfigBytes())
+	}
+}
+
+func (c *Chip) deleteCredentials(ssid string) {
+	for i := range c.stored {
+		if c.stored[i].ssid == ssid {
+			c.stored = append(c.stored[:i], c.stored[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Chip) postDefaultConnect(code int8) {
+	c.post(groupWifi, opWifiRespDefaultConnect, []byte{byte(code), 0, 0, 0})
+}
+
+func (c *Chip) postStateChanged(state, code byte) {
+	c.post(groupWifi, opWifiRespConStateChanged, []byte{state, code, 0, 0})
+}
+
+func (c *Chip) ipConfigBytes() []byte {
+	buf := make([]byte, 24)
+	copy(buf[0:4], c.IPConfig.IP.To4())
+	copy(buf[4:8], c.IPConfig.Gateway.To4())
+	copy(buf[8:12], c.IPConfig.DNS.To4())
+	copy(buf[12:16], c.IPConfig.DNS.To4())
+	copy(buf[16:20], c.IPConfig.Mask.To4())
+	binary.LittleEndian.PutUint32(buf[20:], c.IPConfig.LeaseTime)
+	return buf
+}
@@ -25,6 +25,7 @@ SOFTWARE.
 package winc
 
 import (
+	"context"
 	"encoding/binary"
 	"net"
 	"sync"
@@ -41,22 +42,21 @@ type (
 		sockfd          int8
 		connectedSockfd int8
 
-		offset    uint16
+		offset uint16
+		// sessionId tags every request of the socket like the reference driver does. The firmware echoes it in its
+		// replies, so a late reply for a closed socket doesn't match a new socket with the same descriptor. Replies
+		// with the same key complete the requests in order.
 		sessionId uint16
 		sslFlags  uint8
 
 		driver *WINC
 		mutex  sync.Mutex
 
-		bufferAddr uint32
+		// Sockets accepted by the listening socket
+		acceptChan chan int8
 
-		// Socket reply channels
-		acceptChan  chan int8
-		bindChan    chan *BindReply
-		connectChan chan *ConnectReply
-		listenChan  chan *ListenReply
-		recvChan    chan *RecvReply
-		sendChan    chan *SendReply
+		// Closed when the driver is reset
+		closed chan struct{}
 
 		recvDeadline time.Time
 		sendDeadline time.Time
@@ -159,8 +159,8 @@ const (
 	sslFlagsDelay        = _NBIT7
 
 	hostnameMaxLength = 64
-	maxTcpSocket      = 7
-	maxUdpSocket      = 4
+	maxTcpSocket      = 7 // Most TCP sockets offered by any chip family
+	maxUdpSocket      = 4 // Most UDP sockets offered by any chip family
 	maxSocket         = maxTcpSocket + maxUdpSocket
 	mtu               = 256
 
@@ -177,7 +177,16 @@ const (
 	afInet uint16 = 2
 )
 
-func (s *Socket) Listen(backlog int) (err error) {
+func (s *Socket) Listen(backlog int) error {
+	ctx, cancel := s.driver.replyContext()
+	defer cancel()
+
+	return s.ListenContext(ctx, backlog)
+}
+
+// ListenContext starts listening for incoming connections on the bound socket. ErrOperationTimeout is returned if the
+// deadline of the context passes before the firmware replies.
+func (s *Socket) ListenContext(ctx context.Context, backlog int) (err error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -185,24 +194,20 @@ func (s *Socket) Listen(backlog int) (err error) {
 		return ErrSocketInvalid
 	}
 
-	// Get unique session id
-
 	strListen := listenCmd{
 		socket:    s.sockfd,
 		backlog:   uint8(backlog),
 		sessionID: s.sessionId,
 	}
 
-	// Send the request to the device
-	if err = s.driver.hif.Send(GroupIP, OpcodeSocketListen, strListen.bytes(), nil, 0); err != nil {
+	// Send the request to the device and wait for WINC to accept the incoming connection
+	reply, err := s.driver.request(ctx, s.requestKey(OpcodeSocketListen, strListen.sessionID), OpcodeSocketListen,
+		strListen.bytes(), nil, 0)
+	if err != nil {
 		return
 	}
 
-	// Wait for WINC to accept the incoming connection
-	var strListenReply *ListenReply
-	select {
-	case strListenReply = <-s.listenChan:
-	}
+	strListenReply := reply.(*ListenReply)
 
 	if strListenReply.Status < 0 {
 		// Return the error
@@ -212,7 +217,16 @@ func (s *Socket) Listen(backlog int) (err error) {
 	return
 }
 
-func (s *Socket) Bind(addr net.Addr) (err error) {
+func (s *Socket) Bind(addr net.Addr) error {
+	ctx, cancel := s.driver.replyContext()
+	defer cancel()
+
+	return s.BindContext(ctx, addr)
+}
+
+// BindContext binds the socket to the local address. ErrOperationTimeout is returned if the deadline of the context
+// passes before the firmware replies.
+func (s *Socket) BindContext(ctx context.Context, addr net.Addr) (err error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -245,14 +259,12 @@ func (s *Socket) Bind(addr net.Addr) (err error) {
 		cmd = OpcodeSocketSslBind
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd, strBind.bytes(), nil, 0); err != nil {
+	reply, err := s.driver.request(ctx, s.requestKey(OpcodeSocketBind, strBind.sessionID), cmd, strBind.bytes(), nil, 0)
+	if err != nil {
 		return
 	}
 
-	var strBindReply *BindReply
-	select {
-	case strBindReply = <-s.bindChan:
-	}
+	strBindReply := reply.(*BindReply)
 
 	if strBindReply.Status < 0 {
 		// Return the error
@@ -264,7 +276,16 @@ func (s *Socket) Bind(addr net.Addr) (err error) {
 	return
 }
 
-func (s *Socket) Connect(addr net.Addr) (err error) {
+func (s *Socket) Connect(addr net.Addr) error {
+	ctx, cancel := s.driver.replyContext()
+	defer cancel()
+
+	return s.ConnectContext(ctx, addr)
+}
+
+// ConnectContext connects the socket to the remote address. ErrOperationTimeout is returned if the deadline of the
+// context passes before the firmware replies.
+func (s *Socket) ConnectContext(ctx context.Context, addr net.Addr) (err error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
@@ -298,16 +319,13 @@ func (s *Socket) Connect(addr net.Addr) (err error) {
 		strConnect.sslFlags = s.sslFlags
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd, strConnect.bytes(), nil, 0); err != nil {
+	// Send the request and wait for the response
+	reply, err := s.driver.request(ctx, s.requestKey(OpcodeSocketConnect, 0), cmd, strConnect.bytes(), nil, 0)
+	if err != nil {
 		return
 	}
 
-	var strConnectReply *ConnectReply
-
-	// Wait for the response
-	select {
-	case strConnectReply = <-s.connectChan:
-	}
+	strConnectReply := reply.(*ConnectReply)
 
 	if strConnectReply.Error != 0 {
 		return SocketError(strConnectReply.Error)
@@ -337,7 +355,7 @@ func (s *Socket) Shutdown() (err error) {
 		sessionID: s.sessionId,
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd, strClose.bytes(), nil, 0); err != nil {
+	if err = s.driver.send(GroupIP, cmd, strClose.bytes(), nil, 0); err != nil {
 		return
 	}
 
@@ -369,15 +387,17 @@ func (s *Socket) Secure() (err error) {
 		sessionID: s.sessionId,
 	}
 
-	if err = s.driver.hif.Send(GroupIP, OpcodeSocketSecure, strConnect.bytes(), nil, 0); err != nil {
+	ctx, cancel := s.driver.replyContext()
+	defer cancel()
+
+	// Send the request and wait for the response
+	reply, err := s.driver.request(ctx, s.requestKey(OpcodeSocketConnect, 0), OpcodeSocketSecure, strConnect.bytes(),
+		nil, 0)
+	if err != nil {
 		return
 	}
 
-	// Wait for the response
-	var strConnectReply *ConnectReply
-	select {
-	case strConnectReply = <-s.connectChan:
-	}
+	strConnectReply := reply.(*ConnectReply)
 
 	if strConnectReply.Error < 0 {
 		return SocketError(strConnectReply.Error)
@@ -442,7 +462,7 @@ func (s *Socket) Setsockopt(level, name int, value []byte) (err error) {
 		control = strSetSockOpt.bytes()
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd|protocol.OpcodeReqDataPkt, control, nil, 0); err != nil {
+	if err = s.driver.send(GroupIP, cmd|protocol.OpcodeReqDataPkt, control, nil, 0); err != nil {
 		return ErrSocketInvalid
 	}
 
@@ -468,15 +488,17 @@ func (s *Socket) Send(buf []byte, deadline time.Time) (sz int, err error) {
 		sessionID: s.sessionId,
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd|protocol.OpcodeReqDataPkt, strSend.bytes(), buf, s.offset); err != nil {
+	// Send the request and wait for the response
+	reply, err := s.driver.request(context.Background(), s.requestKey(OpcodeSocketSend, strSend.sessionID),
+		cmd|protocol.OpcodeReqDataPkt, strSend.bytes(), buf, s.offset)
+	if err == net.ErrClosed {
+		// The driver was reset while waiting
+		return 0, err
+	} else if err != nil {
 		return 0, ErrSocketBufferFull
 	}
 
-	// Wait for the response
-	var strSendReply *SendReply
-	select {
-	case strSendReply = <-s.sendChan:
-	}
+	strSendReply := reply.(*SendReply)
 
 	// Check for error
 	if strSendReply.SentBytes < 0 {
@@ -513,15 +535,17 @@ func (s *Socket) SendTo(buf []byte, addr net.Addr, deadline time.Time) (sz int,
 		return 0, ErrInvalidParameter
 	}
 
-	if err = s.driver.hif.Send(GroupIP, OpcodeSocketSendTo|protocol.OpcodeReqDataPkt, strSend.bytes(), buf, udpTxPacketOffset); err != nil {
+	// Send the request and wait for the response
+	reply, err := s.driver.request(context.Background(), s.requestKey(OpcodeSocketSend, strSend.sessionID),
+		OpcodeSocketSendTo|protocol.OpcodeReqDataPkt, strSend.bytes(), buf, udpTxPacketOffset)
+	if err == net.ErrClosed {
+		// The driver was reset while waiting
+		return 0, err
+	} else if err != nil {
 		return 0, ErrSocketBufferFull
 	}
 
-	// Wait for the response
-	var strSendReply *SendReply
-	select {
-	case strSendReply = <-s.sendChan:
-	}
+	strSendReply := reply.(*SendReply)
 
 	// Check for error
 	if strSendReply.SentBytes < 0 {
@@ -561,19 +585,22 @@ func (s *Socket) Recv(buf []byte, deadline time.Time) (sz int, err error) {
 		timeout:   uint32(timeout),
 		socket:    s.sockfd,
 		bufLen:    uint16(len(buf)),
-		sessionID: s.driver.getSessionId(),
+		sessionID: s.sessionId,
 	}
 
-	if err = s.driver.hif.Send(GroupIP, cmd, strRecv.bytes(), nil, 0); err != nil {
+	// Send the request and wait for the reply. The firmware enforces the timeout.
+	reply, err := s.driver.request(context.Background(), s.requestKey(OpcodeSocketRecv, strRecv.sessionID), cmd,
+		strRecv.bytes(), nil, 0)
+	if err == net.ErrClosed {
+		// The driver was reset while waiting
+		return 0, err
+	} else if err != nil {
 		return 0, ErrSocketBufferFull
 	}
 
-	// Wait for the reply
-	var strRecvReply *RecvReply
-	select {
-	case strRecvReply = <-s.recvChan:
-		sz = int(strRecvReply.RecvStatus)
-	}
+	result := reply.(*recvResult)
+	strRecvReply := result.reply
+	sz = int(strRecvReply.RecvStatus)
 
 	if strRecvReply.RecvStatus < 0 {
 		// Return the amount of data actually read and the error
@@ -581,13 +608,23 @@ func (s *Socket) Recv(buf []byte, deadline time.Time) (sz int, err error) {
 	}
 
 	// Receive the payload
-	if err = s.driver.hif.Receive(s.bufferAddr, buf[:sz], true); err != nil {
+	if err = s.driver.hif.Receive(result.bufferAddr, buf[:sz], true); err != nil {
 		return -14, err
 	}
 
 	return
 }
 
+// requestKey returns the key of the reply to a request made on this socket.
+func (s *Socket) requestKey(opcode protocol.OpcodeId, session uint16) requestKey {
+	return requestKey{
+		group:   GroupIP,
+		opcode:  opcode,
+		socket:  s.sockfd,
+		session: session,
+	}
+}
+
 func (s *Socket) RecvFrom(buf []byte, addr net.Addr, deadline time.Time) (sz int, err error) {
 	return s.Recv(buf, deadline)
 }
@@ -597,10 +634,11 @@ func (w *WINC) Socket(sockType SocketType, config SocketConfig) (socket *Socket,
 	defer w.mutex.Unlock()
 
 	sockfd := -1
+	release := releaseOf(w.hif.Family())
 
 	if sockType == SocketTypeStream {
 		// Find available TCP socket
-		for i := 0; i < maxTcpSocket; i++ {
+		for i := 0; i < release.tcpSockets; i++ {
 			if w.sockets[i] == nil {
 				sockfd = i
 				break
@@ -608,7 +646,7 @@ func (w *WINC) Socket(sockType SocketType, config SocketConfig) (socket *Socket,
 		}
 	} else if sockType == SocketTypeDatagram {
 		// Find available UDP socket
-		for i := maxTcpSocket; i < maxSocket; i++ {
+		for i := release.tcpSockets; i < release.tcpSockets+release.udpSockets; i++ {
 			if w.sockets[i] == nil {
 				sockfd = i
 				break
@@ -621,12 +659,8 @@ func (w *WINC) Socket(sockType SocketType, config SocketConfig) (socket *Socket,
 			sockfd: int8(sockfd),
 			driver: w,
 
-			acceptChan:  make(chan int8, 1),
-			bindChan:    make(chan *BindReply, 1),
-			connectChan: make(chan *ConnectReply, 1),
-			listenChan:  make(chan *ListenReply, 1),
-			recvChan:    make(chan *RecvReply, 1),
-			sendChan:    make(chan *SendReply, 1),
+			acceptChan: make(chan int8, 1),
+			closed:     make(chan struct{}),
 		}
 
 		if sockType == SocketTypeStream && config != SocketConfigSslOff {
@@ -669,22 +703,26 @@ func (w *WINC) SocketByDescriptor(sockfd int8) (*Socket, error) {
 	return w.sockets[sockfd], nil
 }
 
-func (w *WINC) GetHostByName(hostname string) (address uint32, err error) {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
+func (w *WINC) GetHostByName(hostname string) (uint32, error) {
+	ctx, cancel := w.replyContext()
+	defer cancel()
+
+	return w.GetHostByNameContext(ctx, hostname)
+}
 
+// GetHostByNameContext resolves the hostname using the DNS client of the firmware. ErrOperationTimeout is returned if
+// the deadline of the context passes before the firmware replies.
+func (w *WINC) GetHostByNameContext(ctx context.Context, hostname string) (address uint32, err error) {
 	buf := make([]byte, len(hostname)+1)
 	if len(hostname) <= hostnameMaxLength {
 		copy(buf, hostname)
-		if err = w.hif.Send(GroupIP, OpcodeSocketDnsResolve, buf, nil, 0); err != nil {
+
+		var reply any
+		if reply, err = w.request(ctx, dnsRequestKey(hostname), OpcodeSocketDnsResolve, buf, nil, 0); err != nil {
 			return
 		}
 
-		select {
-		case reply := <-w.callbackChan:
-			strDnsReply := reply.(*dnsReply)
-			address = strDnsReply.hostIP
-		}
+		address = reply.(*dnsReply).hostIP
 	}
 
 	return
@@ -700,6 +738,22 @@ func (w *WINC) getSessionId() (id uint16) {
 	return
 }
 
+// recvResult is the reply to a receive request along with the location of the received data
+type recvResult struct {
+	reply      *RecvReply
+	bufferAddr uint32
+}
+
+// dnsRequestKey returns the key of the DNS reply. The reply carries the hostname instead of a session ID.
+func dnsRequestKey(hostname string) requestKey {
+	return requestKey{
+		group:  GroupIP,
+		opcode: OpcodeSocketDnsResolve,
+		socket: -1,
+		name:   hostname,
+	}
+}
+
 func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (data any, err error) {
 	switch id {
 	case OpcodeSocketAccept:
@@ -711,7 +765,7 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strAcceptReply := AcceptReply{}
 		strAcceptReply.read(buf)
 
-		if strAcceptReply.ConnectedSock > 0 {
+		if strAcceptReply.ConnectedSock >= 0 && strAcceptReply.ConnectedSock < maxSocket {
 			// Create a socket struct for the connected socket
 			w.sockets[strAcceptReply.ConnectedSock] = &Socket{
 				sockfd:    strAcceptReply.ConnectedSock,
@@ -719,6 +773,9 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 				sessionId: w.getSessionId(),
 				offset:    strAcceptReply.AppDataOffset - protocol.HifHdrOffset,
 				driver:    w,
+
+				acceptChan: make(chan int8, 1),
+				closed:     make(chan struct{}),
 			}
 
 			ip := make([]byte, 0, 4)
@@ -726,12 +783,12 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 			case *net.TCPAddr:
 				w.sockets[strAcceptReply.ConnectedSock].addr = &net.TCPAddr{
 					IP:   binary.LittleEndian.AppendUint32(ip, strAcceptReply.Address.IPAddress),
-					Port: int(strAcceptReply.Address.Port),
+					Port: int(Htons(strAcceptReply.Address.Port)),
 				}
 			case *net.UDPAddr:
 				w.sockets[strAcceptReply.ConnectedSock].addr = &net.UDPAddr{
 					IP:   binary.LittleEndian.AppendUint32(ip, strAcceptReply.Address.IPAddress),
-					Port: int(strAcceptReply.Address.Port),
+					Port: int(Htons(strAcceptReply.Address.Port)),
 				}
 			}
 
@@ -751,9 +808,12 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strBindReply := BindReply{}
 		strBindReply.read(buf)
 
-		if w.sockets[strBindReply.Socket] != nil {
-			w.sockets[strBindReply.Socket].bindChan <- &strBindReply
-		}
+		w.pending.resolve(requestKey{
+			group:   GroupIP,
+			opcode:  OpcodeSocketBind,
+			socket:  strBindReply.Socket,
+			session: strBindReply.SessionID,
+		}, &strBindReply)
 
 		data = strBindReply
 	case OpcodeSocketConnect, OpcodeSocketSslConnect:
@@ -765,9 +825,12 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strConnectReply := ConnectReply{}
 		strConnectReply.read(buf)
 
-		if w.sockets[strConnectReply.Socket] != nil {
-			w.sockets[strConnectReply.Socket].connectChan <- &strConnectReply
-		}
+		// NOTE: The connect reply does not carry the session ID
+		w.pending.resolve(requestKey{
+			group:  GroupIP,
+			opcode: OpcodeSocketConnect,
+			socket: strConnectReply.Socket,
+		}, &strConnectReply)
 
 		data = strConnectReply
 	case OpcodeSocketListen:
@@ -779,9 +842,12 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strListenReply := ListenReply{}
 		strListenReply.read(buf)
 
-		if w.sockets[strListenReply.Socket] != nil {
-			w.sockets[strListenReply.Socket].listenChan <- &strListenReply
-		}
+		w.pending.resolve(requestKey{
+			group:   GroupIP,
+			opcode:  OpcodeSocketListen,
+			socket:  strListenReply.Socket,
+			session: strListenReply.SessionID,
+		}, &strListenReply)
 
 		data = strListenReply
 	case OpcodeSocketRecv, OpcodeSocketSslRecv, OpcodeSocketRecvFrom:
@@ -793,15 +859,22 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strRecvReply := RecvReply{}
 		strRecvReply.read(buf)
 		if strRecvReply.Socket >= 0 && strRecvReply.Socket < maxSocket {
-			if w.sockets[strRecvReply.Socket] != nil {
-				if strRecvReply.RecvStatus > 0 && strRecvReply.RecvStatus < int16(sz) {
-					// Cache data location for Recv can retrieve the data from the WINC firmware directly
-					w.sockets[strRecvReply.Socket].bufferAddr = address + uint32(strRecvReply.DataOffset)
-				}
+			result := &recvResult{
+				reply: &strRecvReply,
+			}
 
-				w.sockets[strRecvReply.Socket].recvChan <- &strRecvReply
+			if strRecvReply.RecvStatus > 0 && strRecvReply.RecvStatus < int16(sz) {
+				// Cache data location for Recv can retrieve the data from the WINC firmware directly
+				result.bufferAddr = address + uint32(strRecvReply.DataOffset)
 			}
 
+			w.pending.resolve(requestKey{
+				group:   GroupIP,
+				opcode:  OpcodeSocketRecv,
+				socket:  strRecvReply.Socket,
+				session: strRecvReply.SessionID,
+			}, result)
+
 			data = strRecvReply
 		} else {
 			return nil, ErrSocketDoesNotExist
@@ -815,9 +888,12 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strSendReply := SendReply{}
 		strSendReply.read(buf)
 
-		if w.sockets[strSendReply.Socket] != nil {
-			w.sockets[strSendReply.Socket].sendChan <- &strSendReply
-		}
+		w.pending.resolve(requestKey{
+			group:   GroupIP,
+			opcode:  OpcodeSocketSend,
+			socket:  strSendReply.Socket,
+			session: strSendReply.SessionID,
+		}, &strSendReply)
 
 		data = strSendReply
 	case OpcodeSocketDnsResolve:
@@ -829,7 +905,7 @@ func (w *WINC) socketCallback(id protocol.OpcodeId, sz uint16, address uint32) (
 		strDnsReply := dnsReply{}
 		strDnsReply.read(buf)
 
-		w.callbackChan <- &strDnsReply
+		w.pending.resolve(dnsRequestKey(strDnsReply.name()), &strDnsReply)
 		data = strDnsReply
 	}
 
@@ -77,7 +77,7 @@ func (s *Socket) Close() error {
 }
 
 func (s *Socket) LocalAddr() net.Addr {
-	return &s.driver.ipAddr
+	return s.driver.localAddr()
 }
 
 func (s *Socket) RemoteAddr() net.Addr {
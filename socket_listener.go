@@ -1,17 +1,31 @@
 package winc
 
 import (
+	"context"
 	"net"
 )
 
 func (s *Socket) Accept() (net.Conn, error) {
+	return s.AcceptContext(context.Background())
+}
+
+// AcceptContext waits for the next incoming connection. ErrOperationTimeout is returned if the deadline of the context
+// passes before a connection is accepted.
+func (s *Socket) AcceptContext(ctx context.Context) (net.Conn, error) {
 	// Check if the socket is valid. If not, it was likely closed
 	if s.sockfd < 0 {
 		return nil, net.ErrClosed
 	}
 
 	// Wait for socket to be ready
-	connectedSockfd := <-s.acceptChan
+	var connectedSockfd int8
+	select {
+	case connectedSockfd = <-s.acceptChan:
+	case <-s.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
 
 	// Return the error if the returned socket is < 0
 	if connectedSockfd < 0 {
@@ -19,7 +33,7 @@ func (s *Socket) Accept() (net.Conn, error) {
 			Op:     "Accept",
 			Net:    s.addr.Network(),
 			Source: s.addr,
-			Addr:   &s.driver.ipAddr,
+			Addr:   s.driver.localAddr(),
 			Err:    SocketError(connectedSockfd),
 		}
 	}
@@ -29,5 +43,5 @@ func (s *Socket) Accept() (net.Conn, error) {
 }
 
 func (s *Socket) Addr() net.Addr {
-	return &s.driver.ipAddr
+	return s.driver.localAddr()
 }
@@ -1,6 +1,5 @@
 package winc
 
-import "C"
 import (
 	"bytes"
 	"encoding/binary"
@@ -148,6 +147,10 @@ func (d *dnsReply) read(buf []byte) {
 	binary.Read(reader, binary.LittleEndian, &d.hostIP)
 }
 
+func (d *dnsReply) name() string {
+	return cString(d.hostName[:])
+}
+
 type listenCmd struct {
 	socket    int8
 	backlog   byte
@@ -1,6 +1,5 @@
 package winc
 
-import "C"
 import (
 	"encoding/binary"
 	"unsafe"
@@ -16,10 +15,6 @@ type EccProvider interface {
 	VerifySignature(address uint32, info *EcdsaVerifyReqInfo) (result *EcdsaVerifyReqInfo, err error)
 }
 
-var (
-	sslReplyChan = make(chan any, 1)
-)
-
 const (
 	sslReqCertificateVerify protocol.OpcodeId = iota
 	sslRequestEcc
@@ -109,10 +104,9 @@ const (
 		SslCipherEcdheRsaWithAes256CbcSha
 )
 
+// handshakeResponse answers an ECC request of the firmware. This is called by the interrupt callback, so it must not
+// take the mutex of the driver.
 func (w *WINC) handshakeResponse(strEccReqInfo []byte, data []byte) (err error) {
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-
 	if err = w.hif.Send(GroupSSL, sslResponseEcc|protocol.OpcodeReqDataPkt, strEccReqInfo, data, uint16(len(strEccReqInfo))); err != nil {
 		return
 	}
@@ -120,14 +114,13 @@ func (w *WINC) handshakeResponse(strEccReqInfo []byte, data []byte) (err error)
 	return
 }
 
+// RetrieveHash reads the hash at the address passed to the EccProvider. The provider is called by the interrupt
+// callback, so this must not take the mutex of the driver.
 func (w *WINC) RetrieveHash(address uint32, buf []byte) (err error) {
 	if address == 0 {
 		return ErrInvalidParameter
 	}
 
-	w.mutex.Lock()
-	defer w.mutex.Unlock()
-
 	if err = w.hif.Receive(address, buf, false); err != nil {
 		return err
 	}
@@ -326,7 +319,7 @@ func (w *WINC) sslCallback(id protocol.OpcodeId, sz uint16, address uint32) (dat
 		//strCsList := sslSetActiveCsList{}
 		//strCsList.read(buf)
 		//
-		//sslReplyChan <- &strCsList
+		//w.sslReplyChan <- &strCsList
 	case sslRespondWriteOwnCertificates:
 	}
 	return
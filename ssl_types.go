@@ -1,6 +1,5 @@
 package winc
 
-import "C"
 import (
 	"bytes"
 	"encoding/binary"
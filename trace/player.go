@@ -0,0 +1,180 @@
+//go:build !tinygo
+
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/waj334/tinygo-winc/hal"
+)
+
+var ErrEndOfTrace = errors.New("end of trace")
+
+// DivergenceError is returned by the Player once the driver shifts out different bytes than it did while the trace
+// was recorded.
+type DivergenceError struct {
+	// Index is the position of the SPI transfer within the trace
+	Index    int
+	Expected []byte
+	Actual   []byte
+}
+
+func (d *DivergenceError) Error() string {
+	return fmt.Sprintf("trace diverged at transfer %d: expected % x, got % x", d.Index, d.Expected, d.Actual)
+}
+
+// Player replays a recorded trace into the driver. It implements drivers.SPI and shifts in the bytes that were
+// recorded while verifying that the driver shifts out the same bytes it did during the recording. Recorded interrupts
+// are raised on the interrupt pin returned by IRQ at the same position in the transfer sequence.
+//
+// The replay is deterministic as long as the application issues the same driver calls in the same order as it did
+// while the trace was recorded.
+type Player struct {
+	records []Record
+	index   int
+	err     error
+	irq     hal.SoftwareInterruptPin
+
+	mutex sync.Mutex
+}
+
+// NewPlayer loads the trace from r.
+func NewPlayer(r io.Reader) (*Player, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Player{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Only the bus transactions are replayed. HIF records are derived from these by the driver.
+		switch record.Kind {
+		case KindTx, KindTransfer, KindInterrupt:
+			p.records = append(p.records, record)
+		}
+	}
+
+	return p, nil
+}
+
+// IRQ returns the interrupt pin that recorded interrupts are raised on.
+func (p *Player) IRQ() *hal.SoftwareInterruptPin {
+	return &p.irq
+}
+
+// Remaining returns the number of SPI transfers and interrupts that were not replayed yet.
+func (p *Player) Remaining() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.records) - p.index
+}
+
+// Err returns the error that stopped the replay, if any.
+func (p *Player) Err() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
+
+func (p *Player) Tx(w, r []byte) error {
+	record, interrupts, err := p.next(KindTx, w, len(r))
+	if err == nil {
+		copy(r, record.Read)
+	}
+
+	p.raise(interrupts)
+	return err
+}
+
+func (p *Player) Transfer(b byte) (byte, error) {
+	record, interrupts, err := p.next(KindTransfer, []byte{b}, 1)
+
+	var result byte
+	if err == nil {
+		result = record.Read[0]
+	}
+
+	p.raise(interrupts)
+	return result, err
+}
+
+// next returns the next SPI transfer in the trace after verifying it against the transfer requested by the driver. It
+// also returns the number of interrupts that were recorded directly after the transfer.
+func (p *Player) next(kind Kind, w []byte, readLen int) (record Record, interrupts int, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.err != nil {
+		return record, 0, p.err
+	}
+
+	// Skip any interrupts that precede the first transfer. These are latched by the interrupt pin.
+	for p.index < len(p.records) && p.records[p.index].Kind == KindInterrupt {
+		p.index++
+		interrupts++
+	}
+
+	if p.index >= len(p.records) {
+		p.err = ErrEndOfTrace
+		return record, interrupts, p.err
+	}
+
+	record = p.records[p.index]
+	if record.Kind != kind || !bytes.Equal(record.Write, w) || (readLen > 0 && len(record.Read) != readLen) {
+		p.err = &DivergenceError{
+			Index:    p.index,
+			Expected: record.Write,
+			Actual:   w,
+		}
+		return record, interrupts, p.err
+	}
+	p.index++
+
+	for p.index < len(p.records) && p.records[p.index].Kind == KindInterrupt {
+		p.index++
+		interrupts++
+	}
+
+	return record, interrupts, nil
+}
+
+func (p *Player) raise(count int) {
+	for i := 0; i < count; i++ {
+		p.irq.Trigger()
+	}
+}
@@ -0,0 +1,286 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package trace records the SPI and HIF transactions of the driver to a portable trace file and replays recorded
+// sessions back into the driver.
+package trace
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+type Kind uint8
+
+const (
+	// KindTx is a buffered SPI transfer
+	KindTx Kind = iota + 1
+	// KindTransfer is a single byte SPI transfer
+	KindTransfer
+	// KindInterrupt is an interrupt signaled by the chip
+	KindInterrupt
+	// KindSend is a HIF packet sent to the chip
+	KindSend
+	// KindIsr is the header of a HIF packet received from the chip
+	KindIsr
+	// KindReceive is a block of a HIF packet read by a callback
+	KindReceive
+)
+
+const (
+	version = 1
+
+	txWrite = 0x01
+	txRead  = 0x02
+)
+
+var (
+	magic = [4]byte{'W', 'T', 'R', 'C'}
+
+	ErrInvalidTrace = errors.New("invalid trace file")
+	ErrVersion      = errors.New("unsupported trace version")
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindTx:
+		return "tx"
+	case KindTransfer:
+		return "transfer"
+	case KindInterrupt:
+		return "interrupt"
+	case KindSend:
+		return "send"
+	case KindIsr:
+		return "isr"
+	case KindReceive:
+		return "receive"
+	default:
+		return "unknown"
+	}
+}
+
+// Record is a single transaction in a trace. Only the fields that are relevant to the kind of record are set.
+type Record struct {
+	Kind Kind
+	// Time is the time elapsed since the beginning of the trace
+	Time time.Duration
+
+	// Write and Read hold the bytes shifted out and in during SPI transfers
+	Write []byte
+	Read  []byte
+
+	Group   protocol.GroupId
+	Opcode  protocol.OpcodeId
+	Length  uint16
+	Offset  uint16
+	Address uint32
+	Control []byte
+	Data    []byte
+	Done    bool
+}
+
+func (r *Record) payload() []byte {
+	var buf []byte
+
+	switch r.Kind {
+	case KindTx:
+		var flags byte
+		if r.Write != nil {
+			flags |= txWrite
+		}
+		if r.Read != nil {
+			flags |= txRead
+		}
+		buf = append(buf, flags)
+		buf = append(buf, r.Write...)
+		buf = append(buf, r.Read...)
+	case KindTransfer:
+		buf = []byte{r.Write[0], r.Read[0]}
+	case KindSend:
+		buf = append(buf, byte(r.Group), byte(r.Opcode))
+		buf = binary.LittleEndian.AppendUint16(buf, r.Offset)
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(r.Control)))
+		buf = append(buf, r.Control...)
+		buf = append(buf, r.Data...)
+	case KindIsr:
+		buf = append(buf, byte(r.Group), byte(r.Opcode))
+		buf = binary.LittleEndian.AppendUint16(buf, r.Length)
+		buf = binary.LittleEndian.AppendUint32(buf, r.Address)
+	case KindReceive:
+		buf = binary.LittleEndian.AppendUint32(buf, r.Address)
+		if r.Done {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+		buf = append(buf, r.Data...)
+	}
+
+	return buf
+}
+
+func (r *Record) read(payload []byte) error {
+	switch r.Kind {
+	case KindTx:
+		if len(payload) < 1 {
+			return ErrInvalidTrace
+		}
+
+		flags := payload[0]
+		payload = payload[1:]
+
+		if flags&txWrite != 0 && flags&txRead != 0 {
+			if len(payload)%2 != 0 {
+				return ErrInvalidTrace
+			}
+			r.Write = payload[:len(payload)/2]
+			r.Read = payload[len(payload)/2:]
+		} else if flags&txWrite != 0 {
+			r.Write = payload
+		} else if flags&txRead != 0 {
+			r.Read = payload
+		}
+	case KindTransfer:
+		if len(payload) != 2 {
+			return ErrInvalidTrace
+		}
+		r.Write = payload[:1]
+		r.Read = payload[1:]
+	case KindInterrupt:
+	case KindSend:
+		if len(payload) < 6 {
+			return ErrInvalidTrace
+		}
+
+		r.Group = protocol.GroupId(payload[0])
+		r.Opcode = protocol.OpcodeId(payload[1])
+		r.Offset = binary.LittleEndian.Uint16(payload[2:])
+
+		controlLen := int(binary.LittleEndian.Uint16(payload[4:]))
+		if len(payload) < 6+controlLen {
+			return ErrInvalidTrace
+		}
+		r.Control = payload[6 : 6+controlLen]
+		r.Data = payload[6+controlLen:]
+	case KindIsr:
+		if len(payload) != 8 {
+			return ErrInvalidTrace
+		}
+
+		r.Group = protocol.GroupId(payload[0])
+		r.Opcode = protocol.OpcodeId(payload[1])
+		r.Length = binary.LittleEndian.Uint16(payload[2:])
+		r.Address = binary.LittleEndian.Uint32(payload[4:])
+	case KindReceive:
+		if len(payload) < 5 {
+			return ErrInvalidTrace
+		}
+
+		r.Address = binary.LittleEndian.Uint32(payload)
+		r.Done = payload[4] != 0
+		r.Data = payload[5:]
+	default:
+		return ErrInvalidTrace
+	}
+
+	return nil
+}
+
+// Writer encodes records to a trace file.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the trace file header to w and returns a Writer for the records that follow.
+func NewWriter(w io.Writer) (*Writer, error) {
+	header := append(magic[:], version)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// Write encodes a single record.
+func (w *Writer) Write(r Record) error {
+	payload := r.payload()
+
+	// Record header: kind, timestamp in nanoseconds and payload length
+	buf := make([]byte, 13, 13+len(payload))
+	buf[0] = byte(r.Kind)
+	binary.LittleEndian.PutUint64(buf[1:], uint64(r.Time))
+	binary.LittleEndian.PutUint32(buf[9:], uint32(len(payload)))
+	buf = append(buf, payload...)
+
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// Reader decodes records from a trace file.
+type Reader struct {
+	r io.Reader
+}
+
+// NewReader validates the trace file header and returns a Reader for the records that follow.
+func NewReader(r io.Reader) (*Reader, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, ErrInvalidTrace
+	}
+
+	if !bytes.Equal(header[:4], magic[:]) {
+		return nil, ErrInvalidTrace
+	} else if header[4] != version {
+		return nil, ErrVersion
+	}
+
+	return &Reader{r: r}, nil
+}
+
+// Next returns the next record in the trace. io.EOF is returned after the last record.
+func (r *Reader) Next() (record Record, err error) {
+	var header [13]byte
+	if _, err = io.ReadFull(r.r, header[:]); err == io.ErrUnexpectedEOF {
+		return record, ErrInvalidTrace
+	} else if err != nil {
+		return
+	}
+
+	record.Kind = Kind(header[0])
+	record.Time = time.Duration(binary.LittleEndian.Uint64(header[1:]))
+
+	payload := make([]byte, binary.LittleEndian.Uint32(header[9:]))
+	if _, err = io.ReadFull(r.r, payload); err != nil {
+		return record, ErrInvalidTrace
+	}
+
+	err = record.read(payload)
+	return
+}
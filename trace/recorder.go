@@ -0,0 +1,194 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package trace
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"tinygo.org/x/drivers"
+
+	"github.com/waj334/tinygo-winc/hal"
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+// Recorder captures every transaction between the driver and the chip. It wraps the SPI bus of the chip and
+// implements protocol.Tracer so that it can be used as WINC.Tracer as well.
+type Recorder struct {
+	spi   drivers.SPI
+	w     *Writer
+	start time.Time
+	err   error
+
+	// Interrupts that occur during a SPI transfer are recorded after the transfer
+	busy    bool
+	pending int
+
+	mutex sync.Mutex
+}
+
+// NewRecorder returns a Recorder that writes the transactions on spi to w.
+func NewRecorder(w io.Writer, spi drivers.SPI) (*Recorder, error) {
+	writer, err := NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		spi:   spi,
+		w:     writer,
+		start: time.Now(),
+	}, nil
+}
+
+// Err returns the first error that occurred while writing the trace.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// InterruptPin wraps the interrupt pin of the chip so that interrupts are recorded.
+func (r *Recorder) InterruptPin(pin hal.InterruptPin) hal.InterruptPin {
+	return &interruptPin{
+		InterruptPin: pin,
+		r:            r,
+	}
+}
+
+func (r *Recorder) Tx(w, rd []byte) (err error) {
+	r.begin()
+	err = r.spi.Tx(w, rd)
+
+	r.end(Record{
+		Kind:  KindTx,
+		Write: w,
+		Read:  rd,
+	})
+	return
+}
+
+func (r *Recorder) Transfer(b byte) (result byte, err error) {
+	r.begin()
+	result, err = r.spi.Transfer(b)
+	r.end(Record{
+		Kind:  KindTransfer,
+		Write: []byte{b},
+		Read:  []byte{result},
+	})
+	return
+}
+
+func (r *Recorder) TraceSend(group protocol.GroupId, opcode protocol.OpcodeId, control, data []byte, offset uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.write(Record{
+		Kind:    KindSend,
+		Group:   group,
+		Opcode:  opcode,
+		Offset:  offset,
+		Control: control,
+		Data:    data,
+	})
+}
+
+func (r *Recorder) TraceIsr(group protocol.GroupId, opcode protocol.OpcodeId, length uint16, address uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.write(Record{
+		Kind:    KindIsr,
+		Group:   group,
+		Opcode:  opcode,
+		Length:  length,
+		Address: address,
+	})
+}
+
+func (r *Recorder) TraceReceive(address uint32, data []byte, done bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.write(Record{
+		Kind:    KindReceive,
+		Address: address,
+		Data:    data,
+		Done:    done,
+	})
+}
+
+func (r *Recorder) begin() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.busy = true
+}
+
+func (r *Recorder) end(record Record) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.write(record)
+	r.busy = false
+
+	// Record the interrupts that were raised during the transfer
+	for ; r.pending > 0; r.pending-- {
+		r.write(Record{Kind: KindInterrupt})
+	}
+}
+
+func (r *Recorder) interrupt() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.busy {
+		r.pending++
+	} else {
+		r.write(Record{Kind: KindInterrupt})
+	}
+}
+
+// write encodes the record. The mutex must be held by the caller.
+func (r *Recorder) write(record Record) {
+	if r.err != nil {
+		return
+	}
+
+	record.Time = time.Since(r.start)
+	r.err = r.w.Write(record)
+}
+
+type interruptPin struct {
+	hal.InterruptPin
+	r *Recorder
+}
+
+func (i *interruptPin) Enable(fn func(hal.Pin)) error {
+	return i.InterruptPin.Enable(func(pin hal.Pin) {
+		i.r.interrupt()
+		fn(pin)
+	})
+}
@@ -0,0 +1,126 @@
+package trace_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	winc "github.com/waj334/tinygo-winc"
+	"github.com/waj334/tinygo-winc/hal"
+	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/simulator"
+	"github.com/waj334/tinygo-winc/trace"
+)
+
+// connect initializes the driver and connects to the test network, returning the IP configuration that was received.
+func connect(t *testing.T, drv *winc.WINC) *winc.IpConfig {
+	t.Helper()
+
+	if err := drv.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	events := drv.OpenEventChannel()
+	if err := drv.WifiConnectPsk(winc.WifiConnectionSettings{
+		Ssid:       "winc",
+		Passphrase: "password",
+	}); err != nil {
+		t.Fatalf("WifiConnectPsk: %v", err)
+	}
+
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case e := <-events:
+			if config, ok := e.Data.(*winc.IpConfig); ok {
+				// Let the driver settle so the trace ends on an idle bus
+				time.Sleep(time.Millisecond * 100)
+				return config
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for IP configuration")
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	chip := simulator.New()
+	chip.AddNetwork("winc", "password")
+
+	var buf bytes.Buffer
+	recorder, err := trace.NewRecorder(&buf, chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv := &winc.WINC{
+		SPI:       recorder,
+		CS:        chip.CS(),
+		IRQ:       recorder.InterruptPin(chip.IRQ()),
+		EnablePin: chip.EnablePin(),
+		ResetPin:  chip.ResetPin(),
+		Tracer:    recorder,
+	}
+
+	expected := connect(t, drv)
+	drv.Reset()
+
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("Recorder: %v", err)
+	}
+
+	player, err := trace.NewPlayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv = &winc.WINC{
+		SPI:       player,
+		CS:        &hal.SoftwarePin{},
+		IRQ:       player.IRQ(),
+		EnablePin: &hal.SoftwarePin{},
+		ResetPin:  &hal.SoftwarePin{},
+	}
+
+	actual := connect(t, drv)
+	drv.Reset()
+
+	if err := player.Err(); err != nil {
+		t.Fatalf("Player: %v", err)
+	}
+
+	if *actual != *expected {
+		t.Errorf("Expected %+v, got %+v", *expected, *actual)
+	}
+
+	if n := player.Remaining(); n != 0 {
+		t.Errorf("Expected the whole trace to be replayed, %d records remaining", n)
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := trace.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Write(trace.Record{Kind: trace.KindTransfer, Write: []byte{0xc4}, Read: []byte{0x00}}); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := trace.NewPlayer(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var divergence *trace.DivergenceError
+	if _, err := player.Transfer(0xc3); !errors.As(err, &divergence) {
+		t.Fatalf("Expected divergence, got %v", err)
+	} else if divergence.Index != 0 {
+		t.Errorf("Expected divergence at 0, got %d", divergence.Index)
+	}
+}
+
+var _ protocol.Tracer = (*trace.Recorder)(nil)
@@ -0,0 +1,41 @@
+package utilities
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+)
+
+// PBKDF2 derives a key of keyLen bytes from password and salt using PBKDF2 with HMAC-SHA1 as the pseudorandom
+// function as described in RFC 8018.
+func PBKDF2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	key := make([]byte, 0, keyLen+sha1.Size)
+	u := make([]byte, sha1.Size)
+	t := make([]byte, sha1.Size)
+
+	var counter [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		binary.BigEndian.PutUint32(counter[:], block)
+
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
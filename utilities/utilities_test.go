@@ -2,6 +2,7 @@ package utilities
 
 import (
 	"bytes"
+	"encoding/hex"
 	"testing"
 )
 
@@ -51,3 +52,56 @@ func Test_pad(t *testing.T) {
 		})
 	}
 }
+
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		expected   string
+	}{
+		{
+			name:       "rfc6070-1",
+			password:   "password",
+			salt:       "salt",
+			iterations: 1,
+			keyLen:     20,
+			expected:   "0c60c80f961f0e71f3a9b524af6012062fe037a6",
+		},
+		{
+			name:       "rfc6070-4096",
+			password:   "password",
+			salt:       "salt",
+			iterations: 4096,
+			keyLen:     20,
+			expected:   "4b007901b765489abead49d926f721d065a429c1",
+		},
+		{
+			name:       "rfc6070-multiple-blocks",
+			password:   "passwordPASSWORDpassword",
+			salt:       "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			iterations: 4096,
+			keyLen:     25,
+			expected:   "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038",
+		},
+		{
+			name:       "ieee-802.11i-psk",
+			password:   "password",
+			salt:       "IEEE",
+			iterations: 4096,
+			keyLen:     32,
+			expected:   "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := PBKDF2([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen)
+
+			if actual := hex.EncodeToString(key); actual != tt.expected {
+				t.Errorf("\nExpected \t%v\nGot \t\t%v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+/*
+MIT License
+
+Copyright (c) 2022 waj334
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package winc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/waj334/tinygo-winc/protocol"
+)
+
+// driverRelease describes the release of the Microchip host driver this driver follows for a chip family.
+type driverRelease struct {
+	version Version
+
+	// Host interface version spoken by the driver. Only the block and the major number have to match the firmware.
+	hif HifVersion
+
+	// First firmware release that publishes its host interface version
+	hifInfoSince Version
+
+	// Number of sockets of each type the firmware offers. UDP sockets are numbered after the TCP sockets.
+	tcpSockets int
+	udpSockets int
+}
+
+var (
+	winc1500Release = driverRelease{
+		version:      Version{19, 7, 7},
+		hif:          HifVersion{Block: 2, Major: 1, Minor: 3},
+		hifInfoSince: Version{19, 6, 0},
+		tcpSockets:   7,
+		udpSockets:   4,
+	}
+
+	winc3400Release = driverRelease{
+		version:      driverVersion(protocol.ChipFamilyWINC3400),
+		hif:          HifVersion{Block: 2, Major: 1, Minor: 3},
+		hifInfoSince: Version{1, 4, 0},
+		tcpSockets:   7,
+		udpSockets:   4,
+	}
+)
+
+// driverVersion returns the driver version announced to the firmware at boot.
+func driverVersion(family protocol.ChipFamily) Version {
+	major, minor, patch := protocol.DriverVersion(family)
+	return Version{major, minor, patch}
+}
+
+func releaseOf(family protocol.ChipFamily) driverRelease {
+	if family == protocol.ChipFamilyWINC3400 {
+		return winc3400Release
+	}
+
+	return winc1500Release
+}
+
+// Version is the version of a firmware or driver release.
+type Version struct {
+	Major uint8
+	Minor uint8
+	Patch uint8
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+func (v Version) less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	} else if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Patch < other.Patch
+}
+
+// HifVersion is the version of the host interface protocol spoken by the firmware.
+type HifVersion struct {
+	Block uint8
+	Major uint8
+	Minor uint8
+}
+
+// hifVersion decodes the host interface info of the firmware. The block number is held in bits 14-15, the major
+// number in bits 8-13 and the minor number in bits 0-7, see M2M_HIF_*_SHIFT of the Microchip driver.
+func hifVersion(info uint16) HifVersion {
+	return HifVersion{
+		Block: uint8(info>>14) & 0x3,
+		Major: uint8(info>>8) & 0x3F,
+		Minor: uint8(info),
+	}
+}
+
+func (h HifVersion) String() string {
+	if h == (HifVersion{}) {
+		return "unknown"
+	}
+
+	return fmt.Sprintf("%d.%d.%d", h.Block, h.Major, h.Minor)
+}
+
+// FirmwareInfo describes the firmware running on the chip.
+type FirmwareInfo struct {
+	ChipID          uint32
+	Family          protocol.ChipFamily
+	FirmwareVersion Version
+	// RequiredDriverVersion is the oldest driver release the firmware works with
+	RequiredDriverVersion Version
+	// HifVersion is unknown for WINC1500 firmware older than 19.6.0 and WINC3400 firmware older than 1.4.0
+	HifVersion  HifVersion
+	BuildDate   string
+	BuildTime   string
+	SvnRevision uint16
+}
+
+func (f *FirmwareInfo) read(data []byte) {
+	f.FirmwareVersion = Version{data[4], data[5], data[6]}
+	f.RequiredDriverVersion = Version{data[7], data[8], data[9]}
+	f.BuildDate = cString(data[10:22])
+	f.BuildTime = cString(data[22:31])
+	binary.Read(bytes.NewReader(data[32:34]), binary.LittleEndian, &f.SvnRevision)
+}
+
+// readVersion reads the version word older firmware publishes in the revision register.
+func (f *FirmwareInfo) readVersion(reg uint32) {
+	f.FirmwareVersion = Version{uint8(reg >> 8), uint8(reg>>4) & 0xF, uint8(reg) & 0xF}
+	f.RequiredDriverVersion = Version{uint8(reg >> 24), uint8(reg>>20) & 0xF, uint8(reg>>16) & 0xF}
+}
+
+// compatible returns a FirmwareVersionError if the driver cannot talk to the firmware.
+func (f *FirmwareInfo) compatible() error {
+	release := releaseOf(f.Family)
+	if f.FirmwareVersion.less(release.hifInfoSince) {
+		// Older firmware does not publish its host interface version. Trust the driver release it asks for instead.
+		if !release.version.less(f.RequiredDriverVersion) {
+			return nil
+		}
+	} else if f.HifVersion.Block == release.hif.Block && f.HifVersion.Major == release.hif.Major {
+		return nil
+	}
+
+	return &FirmwareVersionError{
+		Firmware:    f.FirmwareVersion,
+		FirmwareHif: f.HifVersion,
+		Driver:      release.version,
+		DriverHif:   release.hif,
+	}
+}
+
+// FirmwareInfo returns the version info of the firmware running on the chip.
+func (w *WINC) FirmwareInfo() (*FirmwareInfo, error) {
+	rev, revReg, err := w.hif.GetFirmwareRevision()
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FirmwareInfo{
+		Family: w.hif.Family(),
+	}
+
+	if rev != nil {
+		info.read(rev)
+	} else {
+		info.readVersion(revReg)
+	}
+
+	if !info.FirmwareVersion.less(releaseOf(info.Family).hifInfoSince) {
+		info.HifVersion = hifVersion(uint16(revReg))
+	}
+
+	if info.ChipID, err = w.hif.GetChipId(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
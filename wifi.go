@@ -25,19 +25,37 @@ SOFTWARE.
 package winc
 
 import (
-	"encoding/binary"
+	"context"
+	"net"
 	"time"
 
 	"github.com/waj334/tinygo-winc/debug"
 	"github.com/waj334/tinygo-winc/protocol"
+	"github.com/waj334/tinygo-winc/utilities"
 )
 
 // Wifi opcodes
 
 const (
-	OpcodeWifiReqGetConnInfo = 5
-	OpcodeWifiRespConnInfo   = 6
-	OpcodeWifiRespGetSysTime = 27
+	OpcodeWifiReqSetMacAddress      = 2
+	OpcodeWifiReqGetConnInfo        = 5
+	OpcodeWifiRespConnInfo          = 6
+	OpcodeWifiReqSetDeviceName      = 7
+	OpcodeWifiReqStartProvisionMode = 8
+	OpcodeWifiRespProvisionInfo     = 9
+	OpcodeWifiReqStopProvisionMode  = 10
+	OpcodeWifiReqEnableSntp         = 12
+	OpcodeWifiReqDisableSntp        = 13
+	OpcodeWifiReqScan               = 16
+	OpcodeWifiRespScanDone          = 17
+	OpcodeWifiReqScanResult         = 18
+	OpcodeWifiRespScanResult        = 19
+	OpcodeWifiReqGetSysTime         = 26
+	OpcodeWifiRespGetSysTime        = 27
+	OpcodeWifiReqPassiveScan        = 35
+	OpcodeWifiReqConfigSntp         = 36
+	OpcodeWifiRespBleApiRecv        = 37
+	OpcodeWifiReqBleApiSend         = 38
 )
 
 const (
@@ -63,6 +81,7 @@ const (
 	OpcodeWifiReqConn
 	OpcodeWifiIndConnParam
 	OpcodeWifiReqDhcpFailure
+	OpcodeWifiReqDeleteApId
 )
 
 const (
@@ -70,6 +89,12 @@ const (
 	OpcodeWifiReqDisableAP
 )
 
+const (
+	OpcodeIpReqStaticIpConf protocol.OpcodeId = iota + 10
+	OpcodeIpReqEnableDhcp
+	OpcodeIpReqDisableDhcp
+)
+
 type WifiCredOption uint8
 
 const (
@@ -121,8 +146,17 @@ type WifiConnectionSettings struct {
 	Ssid       string
 	Channel    WifiChannel
 	Passphrase string
-	Storage    WifiCredOption
-	Security   WifiSecurityType
+	// PSK is the 32 byte pre-shared key derived from the passphrase. It is used instead of the passphrase when set so
+	// that the firmware does not need to derive it. See DerivePSK.
+	PSK      []byte
+	Storage  WifiCredOption
+	Security WifiSecurityType
+}
+
+// DerivePSK derives the WPA pre-shared key of a network from its SSID and passphrase. Deriving the key is slow, so
+// applications should cache the result and pass it in WifiConnectionSettings.PSK on later connects.
+func DerivePSK(ssid, passphrase string) []byte {
+	return utilities.PBKDF2([]byte(passphrase), []byte(ssid), 4096, 32)
 }
 
 func (w *WINC) WifiConnectPsk(settings WifiConnectionSettings) (err error) {
@@ -132,7 +166,6 @@ func (w *WINC) WifiConnectPsk(settings WifiConnectionSettings) (err error) {
 	debug.DEBUG("WIFI: WifiConnectPsk - BEGIN")
 	defer debug.DEBUG("WIFI: WifiConnectPsk - END")
 
-	opcode := OpcodeWifiReqConn
 	var data []byte
 
 	if settings.Security == 0 {
@@ -144,27 +177,89 @@ func (w *WINC) WifiConnectPsk(settings WifiConnectionSettings) (err error) {
 		return ErrInvalidParameter
 	}
 
+	if settings.Security == WifiSecurity8021X {
+		// Enterprise networks need the credentials passed to WifiConnectEnterprise
+		return ErrInvalidParameter
+	}
+
 	if settings.Security != WifiSecurityOpen {
-		if len(settings.Passphrase) == 0 || len(settings.Passphrase) >= 64 {
-			return ErrInvalidParameter
-		}
+		var credentials wifiPsk
 
-		opcode |= protocol.OpcodeReqDataPkt
+		if len(settings.PSK) > 0 {
+			if len(settings.PSK) != 32 {
+				return ErrInvalidParameter
+			}
 
-		// TODO: Handle PSK - 2
+			credentials.psk = settings.PSK
+			credentials.pskValue = 1
+		} else {
+			if len(settings.Passphrase) == 0 || len(settings.Passphrase) >= 64 {
+				return ErrInvalidParameter
+			}
 
-		credentials := wifiPsk{
-			passphrase: []byte(settings.Passphrase),
-			psk:        nil,
-			pskValue:   0,
+			credentials.passphrase = []byte(settings.Passphrase)
 		}
 
 		data = credentials.bytes()
 	}
 
+	return w.wifiConnect(settings, data)
+}
+
+// WifiConnectEnterprise connects to a WPA2-Enterprise (802.1X) network. The security type of settings is ignored and
+// its passphrase is unused.
+func (w *WINC) WifiConnectEnterprise(settings WifiConnectionSettings, credentials WifiEnterpriseCredentials) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	debug.DEBUG("WIFI: WifiConnectEnterprise - BEGIN")
+	defer debug.DEBUG("WIFI: WifiConnectEnterprise - END")
+
+	if len(settings.Ssid) == 0 {
+		return ErrInvalidParameter
+	}
+
+	data, err := credentials.bytes()
+	if err != nil {
+		return
+	}
+
+	settings.Security = WifiSecurity8021X
+	return w.wifiConnect(settings, data)
+}
+
+// wifiConnect sends the connection request for settings followed by the authentication data of its security type.
+// The 802.1X credentials don't fit into the packet of the request, so they are sent ahead of it like the Microchip
+// driver does.
+func (w *WINC) wifiConnect(settings WifiConnectionSettings, auth []byte) (err error) {
+	// The address of a previous connection is no longer of interest
+	w.setIpResult(nil)
+
+	// The firmware stores the credentials once the connection is established
+	w.stateMutex.Lock()
+	w.storingNetwork = ""
+	if settings.Storage != WifiCredDontSave {
+		w.storingNetwork = settings.Ssid
+	}
+	w.stateMutex.Unlock()
+
+	data := auth
+	if settings.Security == WifiSecurity8021X {
+		if err = w.hif.Send(GroupWIFI, OpcodeWifiIndConnParam|protocol.OpcodeReqDataPkt, nil, auth, 0); err != nil {
+			return
+		}
+
+		data = nil
+	}
+
+	opcode := OpcodeWifiReqConn
+	if len(data) > 0 {
+		opcode |= protocol.OpcodeReqDataPkt
+	}
+
 	control := wifiConnectionHeader{
 		hdr: wifiConnectionCredentialsHeader{
-			credentialsSize: 152,
+			credentialsSize: int16(44 + len(auth)),
 			storageFlags:    byte(settings.Storage),
 			channel:         byte(settings.Channel),
 		},
@@ -184,46 +279,616 @@ func (w *WINC) WifiConnectPsk(settings WifiConnectionSettings) (err error) {
 	return nil
 }
 
-func (w *WINC) WifiDisconnect() error {
+// StartWPS starts Wi-Fi Protected Setup and waits for the access point to hand out its credentials. The pin is
+// ignored for push button triggers. WPS is disabled again if the context is done before the access point replies.
+func (w *WINC) StartWPS(ctx context.Context, trigger WPSTrigger, pin string) (*WPSCredentials, error) {
+	switch trigger {
+	case WPSTriggerPushButton:
+		pin = ""
+	case WPSTriggerPIN:
+		if len(pin) != 8 {
+			return nil, ErrInvalidParameter
+		}
+
+		for _, c := range pin {
+			if c < '0' || c > '9' {
+				return nil, ErrInvalidParameter
+			}
+		}
+	default:
+		return nil, ErrInvalidParameter
+	}
+
+	req := wpsCmd{
+		trigger: byte(trigger),
+		pin:     pin,
+	}
+
+	reply, err := w.request(ctx, wifiRequestKey(OpcodeWifiReqWps), OpcodeWifiReqWps, req.bytes(), nil, 0)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Stop waiting for the access point
+			w.send(GroupWIFI, OpcodeWifiReqDisableWps, nil, nil, 0)
+		}
+
+		return nil, err
+	}
+
+	credentials := reply.(*WPSCredentials)
+	if credentials.SecurityType == 0 {
+		return nil, ErrWPSFailed
+	}
+
+	return credentials, nil
+}
+
+// ConnectWPS obtains the credentials of the access point using WPS and connects to it. The credentials are stored
+// according to storage.
+func (w *WINC) ConnectWPS(ctx context.Context, trigger WPSTrigger, pin string, storage WifiCredOption) (*WPSCredentials, error) {
+	credentials, err := w.StartWPS(ctx, trigger, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.WifiConnectPsk(credentials.ConnectionSettings(storage)); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+// StartProvisioning starts an access point that serves the HTTP provisioning page of the firmware and waits for a
+// station to submit the credentials of a network. The access point is torn down before returning. Applications that
+// serve their own page should use EnableAP and Listen instead.
+func (w *WINC) StartProvisioning(ctx context.Context, config ProvisioningConfig) (*ProvisionInfo, error) {
+	if err := config.AP.validate(); err != nil {
+		return nil, err
+	} else if len(config.Domain) == 0 || len(config.Domain) > 63 {
+		return nil, ErrInvalidParameter
+	}
+
+	reply, err := w.request(ctx, wifiRequestKey(OpcodeWifiRespProvisionInfo),
+		OpcodeWifiReqStartProvisionMode|protocol.OpcodeReqDataPkt, config.bytes(), nil, 0)
+
+	// Make sure the access point is down even if no station submitted the form
+	if stopErr := w.send(GroupWIFI, OpcodeWifiReqStopProvisionMode, nil, nil, 0); err == nil {
+		err = stopErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := reply.(*ProvisionInfo)
+	if info.Status != 0 {
+		return nil, ErrProvisioningFailed
+	}
+
+	return info, nil
+}
+
+// ConnectProvisioning obtains the credentials of a network using the provisioning mode of the firmware and connects to
+// it. The credentials are stored according to storage.
+func (w *WINC) ConnectProvisioning(ctx context.Context, config ProvisioningConfig, storage WifiCredOption) (*ProvisionInfo, error) {
+	info, err := w.StartProvisioning(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = w.WifiConnectPsk(info.ConnectionSettings(storage)); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// ConnectDefault connects to one of the networks whose credentials the firmware stored during earlier connects. It
+// returns once the connection is established or the firmware reports success. ErrNoStoredCredentials is returned if
+// no credentials are stored.
+func (w *WINC) ConnectDefault(ctx context.Context) error {
+	w.setIpResult(nil)
+
+	// The entries are already stored
+	w.stateMutex.Lock()
+	w.storingNetwork = ""
+	w.defaultConnecting = true
+	w.stateMutex.Unlock()
+
+	defer func() {
+		w.stateMutex.Lock()
+		w.defaultConnecting = false
+		w.stateMutex.Unlock()
+	}()
+
+	key := wifiRequestKey(OpcodeWifiReqDefaultConnect)
+	reply, err := w.request(ctx, key, OpcodeWifiReqDefaultConnect, nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	// The firmware replies on failure. Otherwise, the connection state change completes the request.
+	if reply, ok := reply.(*defaultConnectReply); ok && reply.ErrorCode != 0 {
+		return DefaultConnectError(reply.ErrorCode)
+	}
+
+	return nil
+}
+
+// DeleteStoredCredentials deletes the credentials of the network named ssid from the flash of the firmware.
+func (w *WINC) DeleteStoredCredentials(ssid string) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	return w.hif.Send(GroupWIFI, OpcodeWifiReqDisconnect, nil, nil, 0)
+	if len(ssid) == 0 || len(ssid) > 32 {
+		return ErrInvalidParameter
+	}
+
+	req := deleteApIdCmd{
+		ssid: ssid,
+	}
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqDeleteApId, req.bytes(), nil, 0); err != nil {
+		return err
+	}
+
+	w.stateMutex.Lock()
+	w.removeStoredNetwork(ssid)
+	w.stateMutex.Unlock()
+	return nil
 }
 
-func (w *WINC) GetWifiState() WifiState {
+// DeleteAllStoredCredentials deletes the credentials of all networks from the flash of the firmware.
+func (w *WINC) DeleteAllStoredCredentials() error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	return w.wifiState
+	req := deleteApIdCmd{
+		all: true,
+	}
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqDeleteApId, req.bytes(), nil, 0); err != nil {
+		return err
+	}
+
+	w.stateMutex.Lock()
+	w.storedNetworks = nil
+	w.stateMutex.Unlock()
+	return nil
+}
+
+// StoredNetworks returns the SSIDs of the networks whose credentials the firmware stored, oldest first. The firmware
+// can't list its entries, so the driver tracks the networks connected with a WifiCredOption other than
+// WifiCredDontSave itself. Entries stored before the host started are missing.
+func (w *WINC) StoredNetworks() []string {
+	w.stateMutex.Lock()
+	defer w.stateMutex.Unlock()
+
+	return append([]string(nil), w.storedNetworks...)
+}
+
+// removeStoredNetwork forgets the stored credentials of the network named ssid. Call this with the state mutex held.
+func (w *WINC) removeStoredNetwork(ssid string) {
+	for i := range w.storedNetworks {
+		if w.storedNetworks[i] == ssid {
+			w.storedNetworks = append(w.storedNetworks[:i], w.storedNetworks[i+1:]...)
+			return
+		}
+	}
+}
+
+// WaitForIP waits until DHCP assigned an address to the station and returns the IP configuration. It returns
+// immediately if the address assignment of the current connection already completed. ErrDHCPFailed or an
+// *IPConflictError is returned if no address can be used.
+func (w *WINC) WaitForIP(ctx context.Context) (*IpConfig, error) {
+	w.ipMutex.Lock()
+	reply := w.ipResult
+	if reply == nil {
+		r := w.pending.add(wifiRequestKey(OpcodeWifiReqDhcpConf))
+		w.ipMutex.Unlock()
+
+		var err error
+		if reply, err = w.pending.wait(ctx, r); err != nil {
+			return nil, err
+		}
+	} else {
+		w.ipMutex.Unlock()
+	}
+
+	switch reply := reply.(type) {
+	case *IpConfig:
+		config := *reply
+		return &config, nil
+	case error:
+		return nil, reply
+	}
+
+	return nil, ErrUnknown
 }
 
-func (w *WINC) GetConnectionInfo() (strConnInfo *WifiConnectionInfo, err error) {
+// setIpResult records the outcome of the address assignment and wakes all callers of WaitForIP and the readers of the
+// state channels. Pass nil when a new assignment starts.
+func (w *WINC) setIpResult(result any) {
+	w.ipMutex.Lock()
+	w.ipResult = result
+	config, assigned := result.(*IpConfig)
+	if assigned {
+		w.ipAddr = config.ipNet()
+	}
+
+	if result != nil {
+		for w.pending.notify(wifiRequestKey(OpcodeWifiReqDhcpConf), result) {
+		}
+	}
+	w.ipMutex.Unlock()
+
+	if assigned {
+		w.stateMutex.Lock()
+		w.signalStateChannels()
+		w.stateMutex.Unlock()
+	}
+}
+
+// SetStaticIP configures the address of the station. Disable DHCP before connecting and call this once the station
+// is connected. The DHCP lease time is ignored.
+func (w *WINC) SetStaticIP(config IpConfig) error {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	if err = w.hif.Send(GroupWIFI, OpcodeWifiReqGetConnInfo, nil, nil, 0); err != nil {
-		return
+	if config.StaticIP == 0 || config.SubnetMask == 0 {
+		return ErrInvalidParameter
 	}
 
-	// Wait for reply
-	select {
-	case reply := <-w.callbackChan:
-		strConnInfo = reply.(*WifiConnectionInfo)
+	if err := w.hif.Send(GroupIP, OpcodeIpReqStaticIpConf, config.bytes(), nil, 0); err != nil {
+		return err
 	}
 
-	return
+	w.setIpResult(&config)
+	return nil
 }
 
-func (w *WINC) EnableAP(config APModeConfig) (err error) {
-	// Validate configuration
-	if config.SecurityType == WifiSecurityWep {
+// SetDHCPEnabled turns the DHCP client of the station on or off. It is on by default.
+func (w *WINC) SetDHCPEnabled(enabled bool) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	opcode := OpcodeIpReqDisableDhcp
+	if enabled {
+		opcode = OpcodeIpReqEnableDhcp
+	}
+
+	return w.hif.Send(GroupIP, opcode, nil, nil, 0)
+}
+
+// GetSystemTime returns the UTC time of the firmware. ErrSystemTimeUnknown is returned until the firmware synchronized
+// its clock using SNTP.
+func (w *WINC) GetSystemTime(ctx context.Context) (time.Time, error) {
+	reply, err := w.request(ctx, wifiRequestKey(OpcodeWifiRespGetSysTime), OpcodeWifiReqGetSysTime, nil, nil, 0)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	strSysTime := reply.(*SystemTime)
+	if strSysTime.Year == 0 {
+		return time.Time{}, ErrSystemTimeUnknown
+	}
+
+	return SysTimeToDate(strSysTime), nil
+}
+
+// ConfigureSNTP sets the servers the SNTP client of the firmware synchronizes with. The firmware only holds one server
+// and synchronizes on its own schedule, so a non-zero Interval starts a routine that reconfigures the firmware with the
+// next server of the list and restarts its client every Interval. The routine runs until the next call of ConfigureSNTP
+// or Reset and skips its turn while SNTP is disabled.
+func (w *WINC) ConfigureSNTP(config SNTPConfig) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(config.Servers) == 0 || (len(config.Servers) > 1 && config.Interval <= 0) || config.Interval < 0 {
 		return ErrInvalidParameter
-	} else if config.SecurityType == WifiSecurityWpaPsk && len(config.WPAKey) == 0 {
+	}
+
+	for _, server := range config.Servers {
+		if len(server) == 0 || len(server) > 32 {
+			return ErrInvalidParameter
+		}
+	}
+
+	w.stopSNTPResync()
+
+	if err := w.hif.Send(GroupWIFI, OpcodeWifiReqConfigSntp, sntpConfigBytes(config.Servers[0], config.UseDHCP), nil, 0); err != nil {
+		return err
+	}
+
+	if config.Interval > 0 {
+		w.sntpStop = make(chan struct{})
+		go w.resyncSNTP(config, w.sntpStop)
+	}
+
+	return nil
+}
+
+// stopSNTPResync ends the routine started by ConfigureSNTP. It requires w.mutex, which the routine takes before every
+// resync, so the routine never sends after this returns.
+func (w *WINC) stopSNTPResync() {
+	if w.sntpStop != nil {
+		close(w.sntpStop)
+		w.sntpStop = nil
+	}
+}
+
+func (w *WINC) resyncSNTP(config SNTPConfig, stop <-chan struct{}) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for next := 1; ; next++ {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+
+		w.mutex.Lock()
+		select {
+		case <-stop:
+			w.mutex.Unlock()
+			return
+		default:
+		}
+
+		if !w.sntpDisabled {
+			server := config.Servers[next%len(config.Servers)]
+			err := w.hif.Send(GroupWIFI, OpcodeWifiReqConfigSntp, sntpConfigBytes(server, config.UseDHCP), nil, 0)
+			if err == nil {
+				err = w.hif.Send(GroupWIFI, OpcodeWifiReqDisableSntp, nil, nil, 0)
+			}
+			if err == nil {
+				err = w.hif.Send(GroupWIFI, OpcodeWifiReqEnableSntp, nil, nil, 0)
+			}
+			if err != nil {
+				debug.DEBUG("SNTP resync with %s failed: %v", server, err)
+			}
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// SetSNTPEnabled turns the SNTP client of the firmware on or off. It is on by default.
+func (w *WINC) SetSNTPEnabled(enabled bool) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	opcode := protocol.OpcodeId(OpcodeWifiReqDisableSntp)
+	if enabled {
+		opcode = OpcodeWifiReqEnableSntp
+	}
+
+	if err := w.hif.Send(GroupWIFI, opcode, nil, nil, 0); err != nil {
+		return err
+	}
+
+	w.sntpDisabled = !enabled
+	return nil
+}
+
+// MACAddress returns the MAC address of the station. This is the address programmed into the chip unless it was
+// overridden with SetMACAddress.
+func (w *WINC) MACAddress() (net.HardwareAddr, error) {
+	mac, err := w.hif.GetMacAddress(false)
+	return net.HardwareAddr(mac), err
+}
+
+// OTPMACAddress returns the MAC address programmed into the OTP memory of the chip. ErrNoMACAddress is returned if the
+// chip was not programmed with one.
+func (w *WINC) OTPMACAddress() (net.HardwareAddr, error) {
+	mac, err := w.hif.GetMacAddress(true)
+	if err != nil {
+		return nil, err
+	} else if mac == nil {
+		return nil, ErrNoMACAddress
+	}
+
+	return net.HardwareAddr(mac), nil
+}
+
+// SetMACAddress overrides the MAC address of the station until the chip is reset. Call this before connecting.
+func (w *WINC) SetMACAddress(mac net.HardwareAddr) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if len(mac) != 6 {
 		return ErrInvalidParameter
-	} else if config.Channel < WifiChannel1 || config.Channel > WifiChannel14 {
+	}
+
+	req := make([]byte, 8)
+	copy(req, mac)
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqSetMacAddress, req, nil, 0)
+}
+
+// SetDeviceName sets the hostname the DHCP client of the firmware announces to the DHCP server. The name may hold up to
+// 47 letters, digits and hyphens and may not start or end with a hyphen. Call this before connecting.
+func (w *WINC) SetDeviceName(name string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if !validDeviceName(name) {
 		return ErrInvalidParameter
-	} // TODO check IP
+	}
+
+	req := make([]byte, 48)
+	copy(req, name)
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqSetDeviceName, req, nil, 0)
+}
+
+func validDeviceName(name string) bool {
+	if len(name) == 0 || len(name) > 47 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+func (w *WINC) WifiDisconnect() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.hif.Send(GroupWIFI, OpcodeWifiReqDisconnect, nil, nil, 0)
+}
+
+func (w *WINC) GetWifiState() WifiState {
+	w.stateMutex.Lock()
+	defer w.stateMutex.Unlock()
+
+	return w.wifiState
+}
+
+// openStateChannel returns a channel that is signalled whenever the connection state changes or an address is
+// assigned. Unlike events, signals are never lost. They are coalesced instead, so read the current state with
+// GetWifiState and ipAssigned once woken up. The channel is closed by closeStateChannel or Reset.
+func (w *WINC) openStateChannel() <-chan struct{} {
+	w.stateMutex.Lock()
+	defer w.stateMutex.Unlock()
+
+	c := make(chan struct{}, 1)
+	w.stateChannels = append(w.stateChannels, c)
+	return c
+}
+
+// closeStateChannel closes a channel returned by openStateChannel unless Reset already did.
+func (w *WINC) closeStateChannel(c <-chan struct{}) {
+	w.stateMutex.Lock()
+	defer w.stateMutex.Unlock()
+
+	for i, s := range w.stateChannels {
+		if s == c {
+			w.stateChannels = append(w.stateChannels[:i], w.stateChannels[i+1:]...)
+			close(s)
+			return
+		}
+	}
+}
+
+// signalStateChannels wakes the readers of the state channels. The caller must hold stateMutex.
+func (w *WINC) signalStateChannels() {
+	for _, c := range w.stateChannels {
+		select {
+		case c <- struct{}{}:
+		default:
+			// The reader has yet to catch up with an earlier signal
+		}
+	}
+}
+
+// ipAssigned reports whether an address is assigned to the station.
+func (w *WINC) ipAssigned() bool {
+	w.ipMutex.Lock()
+	defer w.ipMutex.Unlock()
+
+	_, ok := w.ipResult.(*IpConfig)
+	return ok
+}
+
+func (w *WINC) GetConnectionInfo() (*WifiConnectionInfo, error) {
+	ctx, cancel := w.replyContext()
+	defer cancel()
+
+	return w.GetConnectionInfoContext(ctx)
+}
+
+// GetConnectionInfoContext requests the information about the current connection. ErrOperationTimeout is returned if
+// the deadline of the context passes before the firmware replies.
+func (w *WINC) GetConnectionInfoContext(ctx context.Context) (strConnInfo *WifiConnectionInfo, err error) {
+	var reply any
+	if reply, err = w.request(ctx, wifiRequestKey(OpcodeWifiRespConnInfo), OpcodeWifiReqGetConnInfo, nil, nil, 0); err != nil {
+		return
+	}
+
+	return reply.(*WifiConnectionInfo), nil
+}
+
+// Scan searches for access points in range and returns the ones that were found. Only one scan can run at a time.
+func (w *WINC) Scan(ctx context.Context, options ScanOptions) (results []ScanResult, err error) {
+	channel := options.Channel
+	if channel == 0 {
+		channel = WifiChannelAll
+	} else if channel != WifiChannelAll && (channel < WifiChannel1 || channel > WifiChannel14) {
+		return nil, ErrInvalidParameter
+	}
+
+	// The mutex of the driver isn't held while waiting, so that other calls can proceed during the scan
+	w.scanMutex.Lock()
+	defer w.scanMutex.Unlock()
+
+	req := scanCmd{
+		channel: byte(channel),
+	}
+
+	opcode := protocol.OpcodeId(OpcodeWifiReqScan)
+	if options.Passive {
+		opcode = OpcodeWifiReqPassiveScan
+		req.passiveScanTime = uint16(options.PassiveScanTime / time.Millisecond)
+	}
+
+	var reply any
+	if reply, err = w.request(ctx, wifiRequestKey(OpcodeWifiRespScanDone), opcode, req.bytes(), nil, 0); err != nil {
+		return
+	}
+
+	done := reply.(*scanDone)
+	if done.state != 0 {
+		return nil, ErrScanFailed
+	}
+
+	// Request the access points found one by one
+	results = make([]ScanResult, 0, done.count)
+	for i := byte(0); i < done.count; i++ {
+		req := scanResultCmd{
+			index: i,
+		}
+
+		if reply, err = w.request(ctx, scanResultKey(i), OpcodeWifiReqScanResult, req.bytes(), nil, 0); err != nil {
+			return nil, err
+		}
+
+		results = append(results, *reply.(*ScanResult))
+	}
+
+	return
+}
+
+// scanResultKey returns the key of the scan result with the index. A late result requested by an earlier scan does not
+// match it.
+func scanResultKey(index byte) requestKey {
+	key := wifiRequestKey(OpcodeWifiRespScanResult)
+	key.index = index
+	return key
+}
+
+// wifiRequestKey returns the key of a Wi-Fi reply. These replies don't carry a session ID.
+func wifiRequestKey(opcode protocol.OpcodeId) requestKey {
+	return requestKey{
+		group:  GroupWIFI,
+		opcode: opcode,
+		socket: -1,
+	}
+}
+
+func (w *WINC) EnableAP(config APModeConfig) (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if err = config.validate(); err != nil {
+		return
+	}
 
 	if err = w.hif.Send(GroupWIFI, OpcodeWifiReqEnableAP|protocol.OpcodeReqDataPkt,
 		nil, config.bytes(), 0); err != nil {
@@ -235,6 +900,9 @@ func (w *WINC) EnableAP(config APModeConfig) (err error) {
 }
 
 func (w *WINC) DisableAP() (err error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
 	if err = w.hif.Send(GroupWIFI, OpcodeWifiReqDisableAP, nil, nil, 0); err != nil {
 		return
 	}
@@ -253,6 +921,7 @@ func (w *WINC) wifiCallback(id protocol.OpcodeId, sz uint16, address uint32) (ob
 		strSysTime := &SystemTime{}
 		strSysTime.read(data)
 
+		w.pending.resolve(wifiRequestKey(OpcodeWifiRespGetSysTime), strSysTime)
 		obj = strSysTime
 	case OpcodeWifiReqDhcpConf:
 		data := make([]byte, 24)
@@ -263,12 +932,22 @@ func (w *WINC) wifiCallback(id protocol.OpcodeId, sz uint16, address uint32) (ob
 		strIpConfig := &IpConfig{}
 		strIpConfig.read(data)
 
-		w.ipAddr.IP = make([]byte, 4)
-		w.ipAddr.Mask = make([]byte, 4)
-		binary.BigEndian.PutUint32(w.ipAddr.IP, strIpConfig.StaticIP)
-		binary.BigEndian.PutUint32(w.ipAddr.Mask, strIpConfig.SubnetMask)
-
+		w.setIpResult(strIpConfig)
 		obj = strIpConfig
+	case OpcodeWifiReqDhcpFailure:
+		w.setIpResult(ErrDHCPFailed)
+		obj = ErrDHCPFailed
+	case OpcodeWifiRespIpConflict:
+		data := make([]byte, 4)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strConflict := &IPConflictError{}
+		strConflict.read(data)
+
+		w.setIpResult(strConflict)
+		obj = strConflict
 	case OpcodeWifiRespConStateChanged:
 		data := make([]byte, 4)
 		if err = w.hif.Receive(address, data, false); err != nil {
@@ -278,8 +957,39 @@ func (w *WINC) wifiCallback(id protocol.OpcodeId, sz uint16, address uint32) (ob
 		strState := &WifiStateChanged{}
 		strState.read(data)
 
-		w.wifiState = WifiState(strState.CurrentState)
+		state := WifiState(strState.CurrentState)
+		w.stateMutex.Lock()
+		w.wifiState = state
+		if state == WifiStateConnected && w.storingNetwork != "" {
+			w.removeStoredNetwork(w.storingNetwork)
+			w.storedNetworks = append(w.storedNetworks, w.storingNetwork)
+			w.storingNetwork = ""
+		}
+
+		completesDefaultConnect := state == WifiStateConnected && w.defaultConnecting
+		w.signalStateChannels()
+		w.stateMutex.Unlock()
+
+		if completesDefaultConnect {
+			w.pending.notify(wifiRequestKey(OpcodeWifiReqDefaultConnect), strState)
+		} else if state == WifiStateDisconnected {
+			// The address is released with the connection
+			w.setIpResult(nil)
+		}
 		obj = strState
+	case OpcodeWifiRespWifiRxPacket:
+		err = w.receiveMonitorFrame(sz, address)
+	case OpcodeWifiRespDefaultConnect:
+		data := make([]byte, 4)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strDefaultConnect := &defaultConnectReply{}
+		strDefaultConnect.read(data)
+
+		w.pending.resolve(wifiRequestKey(OpcodeWifiReqDefaultConnect), strDefaultConnect)
+		obj = DefaultConnectError(strDefaultConnect.ErrorCode)
 	case OpcodeWifiRespConnInfo:
 		data := make([]byte, 48)
 		if err = w.hif.Receive(address, data, false); err != nil {
@@ -289,8 +999,52 @@ func (w *WINC) wifiCallback(id protocol.OpcodeId, sz uint16, address uint32) (ob
 		strConnInfo := &WifiConnectionInfo{}
 		strConnInfo.read(data)
 
-		w.callbackChan <- strConnInfo
+		w.pending.resolve(wifiRequestKey(OpcodeWifiRespConnInfo), strConnInfo)
 		obj = strConnInfo
+	case OpcodeWifiReqWps:
+		data := make([]byte, 100)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strWpsInfo := &WPSCredentials{}
+		strWpsInfo.read(data)
+
+		w.pending.resolve(wifiRequestKey(OpcodeWifiReqWps), strWpsInfo)
+	case OpcodeWifiRespProvisionInfo:
+		data := make([]byte, 100)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strProvisionInfo := &ProvisionInfo{}
+		strProvisionInfo.read(data)
+
+		w.pending.resolve(wifiRequestKey(OpcodeWifiRespProvisionInfo), strProvisionInfo)
+		obj = strProvisionInfo
+	case OpcodeWifiRespScanDone:
+		data := make([]byte, 4)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strScanDone := &scanDone{}
+		strScanDone.read(data)
+
+		w.pending.resolve(wifiRequestKey(OpcodeWifiRespScanDone), strScanDone)
+	case OpcodeWifiRespScanResult:
+		data := make([]byte, 44)
+		if err = w.hif.Receive(address, data, false); err != nil {
+			return
+		}
+
+		strScanResult := &ScanResult{}
+		strScanResult.read(data)
+
+		w.pending.resolve(scanResultKey(strScanResult.Index), strScanResult)
+		obj = strScanResult
+	case OpcodeWifiRespBleApiRecv:
+		obj, err = w.bleReceive(address, sz)
 	}
 
 	return
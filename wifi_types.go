@@ -3,6 +3,9 @@ package winc
 import (
 	"bytes"
 	"encoding/binary"
+	"net"
+	"time"
+
 	"github.com/waj334/tinygo-winc/utilities"
 )
 
@@ -81,7 +84,7 @@ type wifiPsk struct {
 	/* passphraseLen uint8 */
 	passphrase []byte // 64 bytes
 	psk        []byte // 40 bytes
-	pskValue   byte
+	pskValue   byte   // Set when psk holds the derived key
 	/* reserved [2]byte */
 	// 108 bytes
 }
@@ -111,6 +114,179 @@ func (w *wifiPsk) bytes() []byte {
 	return buf.Bytes()
 }
 
+type WifiEnterpriseMethod uint8
+
+const (
+	// WifiEnterpriseMSCHAPv2 authenticates with a username and password using PEAP/MSCHAPv2
+	WifiEnterpriseMSCHAPv2 WifiEnterpriseMethod = iota
+	// WifiEnterpriseTLS authenticates with a client certificate and its RSA private key using EAP-TLS
+	WifiEnterpriseTLS
+)
+
+const (
+	wifi1xFlagMSCHAPv2            = 0x01
+	wifi1xFlagTLS                 = 0x02
+	wifi1xFlagPrependDomain       = 0x40
+	wifi1xFlagUnencryptedUsername = 0x80
+
+	wifi1xHeaderSize         = 44
+	wifi1xMaxUsernameSize    = 100
+	wifi1xMaxPasswordSize    = 256
+	wifi1xMaxPrivateKeySize  = 256
+	wifi1xMaxCertificateSize = 1584
+
+	// wifi1xMaxSize is the size of the largest credentials that fit into a HIF packet of 1596 bytes after its 8 byte
+	// header
+	wifi1xMaxSize = 1596 - 8 - 1
+)
+
+// WifiEnterpriseCredentials are the credentials used to authenticate with a WPA2-Enterprise network.
+type WifiEnterpriseCredentials struct {
+	Method   WifiEnterpriseMethod
+	Username string
+	// Domain is optional and is joined with the username as "user@domain" or as "domain\user" if PrependDomain is set
+	Domain        string
+	PrependDomain bool
+	// UnencryptedUsername sends the username as the outer identity instead of "anonymous"
+	UnencryptedUsername bool
+
+	// Password is used by WifiEnterpriseMSCHAPv2
+	Password string
+
+	// Certificate is the DER encoded client certificate used by WifiEnterpriseTLS. All credentials must fit into 1587
+	// bytes, which leaves about 1000 bytes for the certificate next to a 2048-bit key.
+	Certificate []byte
+	// PrivateKeyModulus and PrivateKeyExponent are the big-endian modulus and private exponent of the RSA key of the
+	// client certificate
+	PrivateKeyModulus  []byte
+	PrivateKeyExponent []byte
+}
+
+// bytes encodes the credentials as a 44 byte header followed by the domain, the username and the secrets. The
+// offsets in the header are relative to the start of the header.
+func (w *WifiEnterpriseCredentials) bytes() ([]byte, error) {
+	if len(w.Username) == 0 || len(w.Domain)+len(w.Username) > wifi1xMaxUsernameSize {
+		return nil, ErrInvalidParameter
+	}
+
+	var flags byte
+	var key, certificate []byte
+	var keySize int
+
+	switch w.Method {
+	case WifiEnterpriseMSCHAPv2:
+		if len(w.Password) == 0 || len(w.Password) > wifi1xMaxPasswordSize {
+			return nil, ErrInvalidParameter
+		}
+
+		flags = wifi1xFlagMSCHAPv2
+		key = []byte(w.Password)
+		keySize = len(key)
+	case WifiEnterpriseTLS:
+		size := len(w.PrivateKeyModulus)
+		if size == 0 || size > wifi1xMaxPrivateKeySize || len(w.PrivateKeyExponent) == 0 ||
+			len(w.PrivateKeyExponent) > size || len(w.Certificate) == 0 ||
+			len(w.Certificate) > wifi1xMaxCertificateSize {
+			return nil, ErrInvalidParameter
+		}
+
+		flags = wifi1xFlagTLS
+
+		// The exponent is zero extended to the size of the modulus
+		key = make([]byte, 2*size)
+		copy(key, w.PrivateKeyModulus)
+		copy(key[2*size-len(w.PrivateKeyExponent):], w.PrivateKeyExponent)
+		keySize = size
+		certificate = w.Certificate
+	default:
+		return nil, ErrInvalidParameter
+	}
+
+	if w.PrependDomain {
+		flags |= wifi1xFlagPrependDomain
+	}
+
+	if w.UnencryptedUsername {
+		flags |= wifi1xFlagUnencryptedUsername
+	}
+
+	keyOffset := wifi1xHeaderSize + len(w.Domain) + len(w.Username)
+	certificateOffset := keyOffset + len(key)
+	if certificateOffset+len(certificate) > wifi1xMaxSize {
+		return nil, ErrInvalidParameter
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, certificateOffset+len(certificate)))
+
+	buf.WriteByte(flags)
+	buf.WriteByte(byte(len(w.Domain)))
+	buf.WriteByte(byte(len(w.Username)))
+	buf.WriteByte(wifi1xHeaderSize)
+	binary.Write(buf, binary.LittleEndian, uint16(keyOffset))
+	binary.Write(buf, binary.LittleEndian, uint16(keySize))
+	binary.Write(buf, binary.LittleEndian, uint16(certificateOffset))
+	binary.Write(buf, binary.LittleEndian, uint16(len(certificate)))
+	utilities.Pad(0, 20, buf) // Root certificate name SHA1
+	utilities.Pad(0, 12, buf) // Reserved and TLS handshake flags
+
+	buf.WriteString(w.Domain)
+	buf.WriteString(w.Username)
+	buf.Write(key)
+	buf.Write(certificate)
+
+	return buf.Bytes(), nil
+}
+
+type defaultConnectReply struct {
+	ErrorCode int8
+	/* padding [3]byte */
+	// 4 bytes
+}
+
+func (d *defaultConnectReply) read(data []byte) {
+	d.ErrorCode = int8(data[0])
+}
+
+type deleteApIdCmd struct {
+	all bool
+	/* ssidLen byte */
+	ssid string // 33 bytes
+	/* padding [2]byte */
+	// 36 bytes
+}
+
+func (d *deleteApIdCmd) bytes() []byte {
+	buf := make([]byte, 36)
+	if d.all {
+		buf[0] = 0xFF
+	} else {
+		buf[0] = byte(len(d.ssid))
+		copy(buf[1:34], d.ssid)
+	}
+	return buf
+}
+
+// SNTPConfig selects the servers of the SNTP client of the firmware.
+type SNTPConfig struct {
+	// Servers are the hostnames of the servers, at most 32 characters each. They may start with a wildcard like
+	// "*.pool.ntp.org". The first server is configured right away and the others take turns at every Interval, so more
+	// than one server requires an Interval.
+	Servers []string
+	// UseDHCP prefers the server announced by the DHCP server over Servers
+	UseDHCP bool
+	// Interval between the resyncs started by the driver. The firmware only synchronizes on its own schedule when zero.
+	Interval time.Duration
+}
+
+func sntpConfigBytes(server string, useDHCP bool) []byte {
+	buf := make([]byte, 36)
+	copy(buf[:33], server)
+	if useDHCP {
+		buf[33] = 1
+	}
+	return buf
+}
+
 type SystemTime struct {
 	Year   uint16
 	Month  byte
@@ -141,6 +317,42 @@ type IpConfig struct {
 	DhcpLeaseTime uint32
 }
 
+func (i *IpConfig) bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 24))
+
+	binary.Write(buf, binary.LittleEndian, i.StaticIP)
+	binary.Write(buf, binary.LittleEndian, i.Gateway)
+	binary.Write(buf, binary.LittleEndian, i.DNS)
+	binary.Write(buf, binary.LittleEndian, i.AlternateDNS)
+	binary.Write(buf, binary.LittleEndian, i.SubnetMask)
+	binary.Write(buf, binary.LittleEndian, i.DhcpLeaseTime)
+
+	return buf.Bytes()
+}
+
+func (i *IpConfig) ipNet() net.IPNet {
+	return net.IPNet{
+		IP:   Uint32ToIPv4(i.StaticIP),
+		Mask: net.IPMask(Uint32ToIPv4(i.SubnetMask)),
+	}
+}
+
+// IPv4ToUint32 converts an IPv4 address to the representation used by IpConfig.
+func IPv4ToUint32(ip net.IP) uint32 {
+	if ip = ip.To4(); ip == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(ip)
+}
+
+// Uint32ToIPv4 converts an address of IpConfig to an IPv4 address.
+func Uint32ToIPv4(addr uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.LittleEndian.PutUint32(ip, addr)
+	return ip
+}
+
 func (i *IpConfig) read(data []byte) {
 	reader := bytes.NewReader(data)
 	binary.Read(reader, binary.LittleEndian, &i.StaticIP)
@@ -179,8 +391,7 @@ type WifiConnectionInfo struct {
 
 func (w *WifiConnectionInfo) read(data []byte) {
 	reader := bytes.NewBuffer(data)
-	w.SSID, _ = reader.ReadString(0)
-	reader.Next(33 - len(w.SSID))
+	w.SSID = cString(reader.Next(33))
 
 	secType, _ := reader.ReadByte()
 	w.SecurityType = WifiSecurityType(secType)
@@ -273,3 +484,275 @@ func (a *APModeConfig) bytes() []byte {
 	buf.Write(a.APConfigExt.bytes())
 	return buf.Bytes()
 }
+
+func (a *APModeConfig) validate() error {
+	if a.SecurityType == WifiSecurityWep {
+		return ErrInvalidParameter
+	} else if a.SecurityType == WifiSecurityWpaPsk && len(a.WPAKey) == 0 {
+		return ErrInvalidParameter
+	} else if a.Channel < WifiChannel1 || a.Channel > WifiChannel14 {
+		return ErrInvalidParameter
+	} // TODO check IP
+
+	return nil
+}
+
+// ProvisioningConfig configures the provisioning mode of the firmware.
+type ProvisioningConfig struct {
+	// AP is the access point stations connect to in order to reach the provisioning page
+	AP APModeConfig
+	// Domain is the name the provisioning page is served under, like "setup.example.com"
+	Domain string // 64 bytes
+	// Redirect sends every HTTP request of a connected station to the provisioning page
+	Redirect bool
+}
+
+func (p *ProvisioningConfig) bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 216))
+	buf.Write(p.AP.APConfig.bytes())
+
+	buf.WriteString(p.Domain)
+	utilities.Pad(len(p.Domain), 64, buf)
+
+	if p.Redirect {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	utilities.Pad(0, 3, buf)
+
+	buf.Write(p.AP.APConfigExt.bytes())
+	return buf.Bytes()
+}
+
+// ProvisionInfo holds the credentials a station submitted on the provisioning page.
+type ProvisionInfo struct {
+	SSID         string // 33 bytes
+	Passphrase   string // 65 bytes
+	SecurityType WifiSecurityType
+	Status       byte // Zero if the credentials were submitted
+	// 100 bytes
+}
+
+func (p *ProvisionInfo) read(data []byte) {
+	p.SSID = cString(data[0:33])
+	p.Passphrase = cString(data[33:98])
+	p.SecurityType = WifiSecurityType(data[98])
+	p.Status = data[99]
+}
+
+// ConnectionSettings returns the settings to connect to the network with.
+func (p *ProvisionInfo) ConnectionSettings(storage WifiCredOption) WifiConnectionSettings {
+	return WifiConnectionSettings{
+		Ssid:       p.SSID,
+		Channel:    WifiChannelAll,
+		Passphrase: p.Passphrase,
+		Storage:    storage,
+		Security:   p.SecurityType,
+	}
+}
+
+// ScanOptions configures a scan for access points.
+type ScanOptions struct {
+	// Channel limits the scan to a single channel. All channels are scanned when zero.
+	Channel WifiChannel
+	// Passive listens for beacons instead of sending probe requests
+	Passive bool
+	// PassiveScanTime is the time spent on each channel during a passive scan. The firmware default is used when zero.
+	PassiveScanTime time.Duration
+}
+
+type scanCmd struct {
+	channel byte
+	/* reserved byte */
+	passiveScanTime uint16
+	// 4 bytes
+}
+
+func (s *scanCmd) bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 4))
+	buf.WriteByte(s.channel)
+	buf.WriteByte(0)
+	binary.Write(buf, binary.LittleEndian, s.passiveScanTime)
+	return buf.Bytes()
+}
+
+type scanDone struct {
+	count byte
+	state int8
+	/* padding [2]byte */
+	// 4 bytes
+}
+
+func (s *scanDone) read(data []byte) {
+	s.count = data[0]
+	s.state = int8(data[1])
+}
+
+type scanResultCmd struct {
+	index byte
+	/* padding [3]byte */
+	// 4 bytes
+}
+
+func (s *scanResultCmd) bytes() []byte {
+	return []byte{s.index, 0, 0, 0}
+}
+
+type ScanResult struct {
+	Index        uint8  // Position in the results of the firmware
+	SSID         string // 33 bytes
+	BSSID        [6]byte
+	RSSI         int8
+	Channel      WifiChannel
+	SecurityType WifiSecurityType
+	// 44 bytes
+}
+
+func (s *ScanResult) read(data []byte) {
+	s.Index = data[0]
+	s.RSSI = int8(data[1])
+	s.SecurityType = WifiSecurityType(data[2])
+	s.Channel = WifiChannel(data[3])
+	copy(s.BSSID[:], data[4:10])
+	s.SSID = cString(data[10:43])
+}
+
+type WPSTrigger uint8
+
+const (
+	WPSTriggerPIN        WPSTrigger = 0
+	WPSTriggerPushButton WPSTrigger = 4
+)
+
+type wpsCmd struct {
+	trigger byte
+	pin     string // 8 bytes
+	/* padding [3]byte */
+	// 12 bytes
+}
+
+func (w *wpsCmd) bytes() []byte {
+	buf := make([]byte, 12)
+	buf[0] = w.trigger
+	copy(buf[1:9], w.pin)
+	return buf
+}
+
+// WPSCredentials are the credentials handed out by the access point during WPS.
+type WPSCredentials struct {
+	SecurityType WifiSecurityType
+	Channel      WifiChannel
+	SSID         string // 33 bytes
+	Passphrase   string // 65 bytes
+	// 100 bytes
+}
+
+func (w *WPSCredentials) read(data []byte) {
+	w.SecurityType = WifiSecurityType(data[0])
+	w.Channel = WifiChannel(data[1])
+	w.SSID = cString(data[2:35])
+	w.Passphrase = cString(data[35:100])
+}
+
+// ConnectionSettings returns the settings to connect to the access point with.
+func (w *WPSCredentials) ConnectionSettings(storage WifiCredOption) WifiConnectionSettings {
+	return WifiConnectionSettings{
+		Ssid:       w.SSID,
+		Channel:    w.Channel,
+		Passphrase: w.Passphrase,
+		Storage:    storage,
+		Security:   w.SecurityType,
+	}
+}
+
+// cString returns the string up to the first null byte.
+func cString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+
+	return string(data)
+}
+
+type monitorModeCtrl struct {
+	channel     byte
+	frameType   byte
+	subtype     byte
+	source      [6]byte
+	destination [6]byte
+	bssid       [6]byte
+	headerOnly  bool
+	/* padding [2]byte */
+	// 24 bytes
+}
+
+func (m *monitorModeCtrl) bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 24))
+
+	buf.WriteByte(m.channel)
+	buf.WriteByte(m.frameType)
+	buf.WriteByte(m.subtype)
+	buf.Write(m.source[:])
+	buf.Write(m.destination[:])
+	buf.Write(m.bssid[:])
+	if m.headerOnly {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.WriteByte(0) // Padding
+	buf.WriteByte(0) // Padding
+
+	return buf.Bytes()
+}
+
+type rxPacketInfo struct {
+	frameType    byte
+	subtype      byte
+	serviceClass byte
+	priority     byte
+	headerLength byte
+	cipherType   byte
+	source       [6]byte
+	destination  [6]byte
+	bssid        [6]byte
+	dataLength   uint16
+	frameLength  uint16
+	dataRate     uint32
+	rssi         int8
+	/* padding [3]byte */
+	// 36 bytes
+}
+
+func (r *rxPacketInfo) read(data []byte) {
+	reader := bytes.NewReader(data)
+	r.frameType, _ = reader.ReadByte()
+	r.subtype, _ = reader.ReadByte()
+	r.serviceClass, _ = reader.ReadByte()
+	r.priority, _ = reader.ReadByte()
+	r.headerLength, _ = reader.ReadByte()
+	r.cipherType, _ = reader.ReadByte()
+	reader.Read(r.source[:])
+	reader.Read(r.destination[:])
+	reader.Read(r.bssid[:])
+	binary.Read(reader, binary.LittleEndian, &r.dataLength)
+	binary.Read(reader, binary.LittleEndian, &r.frameLength)
+	binary.Read(reader, binary.LittleEndian, &r.dataRate)
+	binary.Read(reader, binary.LittleEndian, &r.rssi)
+}
+
+type txPacketInfo struct {
+	packetSize   uint16
+	headerLength uint16
+	// 4 bytes
+}
+
+func (t *txPacketInfo) bytes() []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 4))
+
+	binary.Write(buf, binary.LittleEndian, t.packetSize)
+	binary.Write(buf, binary.LittleEndian, t.headerLength)
+
+	return buf.Bytes()
+}